@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	allContextsFlag = "all-contexts"
+	contextsFlag    = "contexts"
+	parallelFlag    = "parallel"
+)
+
+// clusterResult is the outcome of running a command against one context.
+type clusterResult struct {
+	Context string      `json:"context" yaml:"context"`
+	Result  interface{} `json:"result,omitempty" yaml:"result,omitempty"`
+	Error   string      `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool(allContextsFlag, false, "Run against every context in the kubeconfig")
+	cmd.Flags().StringSlice(contextsFlag, nil, "Run against these contexts, e.g. a,b,c")
+	cmd.Flags().Int(parallelFlag, 5, "Number of contexts to run against concurrently")
+}
+
+// batchContexts returns the contexts selected with --all-contexts or
+// --contexts, or nil if the command runs against a single cluster.
+func batchContexts(cmd *cobra.Command) ([]string, error) {
+	all, err := cmd.Flags().GetBool(allContextsFlag)
+	if err != nil {
+		return nil, err
+	}
+	contexts, err := cmd.Flags().GetStringSlice(contextsFlag)
+	if err != nil {
+		return nil, err
+	}
+	if all && len(contexts) > 0 {
+		return nil, errors.New("--all-contexts and --contexts are mutually exclusive")
+	}
+	if !all {
+		return contexts, nil
+	}
+	raw, err := KubernetesConfigFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	for name := range raw.Contexts {
+		contexts = append(contexts, name)
+	}
+	if len(contexts) == 0 {
+		return nil, errors.New("no contexts found in kubeconfig")
+	}
+	sort.Strings(contexts)
+	return contexts, nil
+}
+
+// batchFunc runs a command against the cluster of a context.
+type batchFunc func(context string, getter genericclioptions.RESTClientGetter) (interface{}, error)
+
+// runBatch calls fn for every context with at most parallel calls running at
+// once. A failing context does not stop the others.
+func runBatch(contexts []string, parallel int, fn batchFunc) []clusterResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	results := make([]clusterResult, len(contexts))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i := range contexts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runContext(contexts[i], fn)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+func runContext(context string, fn batchFunc) (result clusterResult) {
+	result.Context = context
+	defer func() {
+		if r := recover(); r != nil {
+			result.Error = fmt.Sprintf("%v", r)
+		}
+	}()
+	out, err := fn(context, configFlagsForContext(KubernetesConfigFlags, context))
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Result = out
+	return
+}
+
+// contextSlugs returns a name for each context that is safe in IAM names and
+// URL paths: the cluster name for the ARNs EKS uses as context names, with
+// other characters than letters, digits, '.', '_' and '-' replaced by '-'.
+// It fails if two contexts get the same name.
+func contextSlugs(contexts []string) (map[string]string, error) {
+	slugs := map[string]string{}
+	seen := map[string]string{}
+	for _, c := range contexts {
+		slug := c
+		if strings.HasPrefix(slug, "arn:") {
+			slug = slug[strings.LastIndex(slug, "/")+1:]
+		}
+		slug = unsafeSlugChars.ReplaceAllString(slug, "-")
+		if other, ok := seen[slug]; ok {
+			return nil, fmt.Errorf("contexts %s and %s both map to the name %s", other, c, slug)
+		}
+		seen[slug] = c
+		slugs[c] = slug
+	}
+	return slugs, nil
+}
+
+var unsafeSlugChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// configFlagsForContext copies the kubectl flags given on the command line and
+// selects the context.
+func configFlagsForContext(base *genericclioptions.ConfigFlags, context string) *genericclioptions.ConfigFlags {
+	f := genericclioptions.NewConfigFlags(true)
+	f.CacheDir = base.CacheDir
+	f.KubeConfig = base.KubeConfig
+	f.ClusterName = base.ClusterName
+	f.AuthInfoName = base.AuthInfoName
+	f.Context = &context
+	f.Namespace = base.Namespace
+	f.APIServer = base.APIServer
+	f.TLSServerName = base.TLSServerName
+	f.Insecure = base.Insecure
+	f.CertFile = base.CertFile
+	f.KeyFile = base.KeyFile
+	f.CAFile = base.CAFile
+	f.BearerToken = base.BearerToken
+	f.Impersonate = base.Impersonate
+	f.ImpersonateUID = base.ImpersonateUID
+	f.ImpersonateGroup = base.ImpersonateGroup
+	f.Username = base.Username
+	f.Password = base.Password
+	f.Timeout = base.Timeout
+	return f
+}
+
+// printBatchReport prints the results as json, yaml or, by default, a table.
+// It returns an error if any context failed.
+func printBatchReport(results []clusterResult, output string) error {
+	switch output {
+	case "json":
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case "yaml":
+		b, err := yaml.Marshal(results)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case "", "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "CONTEXT\tSTATUS\tDETAIL")
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Fprintf(w, "%s\tFAILED\t%s\n", r.Context, r.Error)
+			} else {
+				fmt.Fprintf(w, "%s\tOK\t%s\n", r.Context, summary(r.Result))
+			}
+		}
+		w.Flush()
+	default:
+		return fmt.Errorf("output format %s not supported", output)
+	}
+	failed := []string{}
+	for _, r := range results {
+		if r.Error != "" {
+			failed = append(failed, r.Context)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d contexts failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func summary(result interface{}) string {
+	if s, ok := result.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%v", result)
+}
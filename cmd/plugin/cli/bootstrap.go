@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/apiserver"
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/publish"
+	"github.com/spf13/cobra"
+)
+
+const (
+	bucketFlag       = "bucket"
+	regionFlag       = "region"
+	prefixFlag       = "prefix"
+	keyDirFlag       = "key-dir"
+	keyBitsFlag      = "key-bits"
+	pkiDirFlag       = "apiserver-pki-dir"
+	apiAudiencesFlag = "api-audiences"
+
+	signingKeyFile = "sa.key"
+	publicKeyFile  = "sa.pub"
+)
+
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "create a signing key and publish its oidc config to s3 before the cluster exists",
+	Long: `create a service account signing key, publish oidc config and jwks for it to s3,
+create the oidc provider in IAM and print the API server flags to use`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		profile, err := cmd.Flags().GetString(awsProfile)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		bucket, err := cmd.Flags().GetString(bucketFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		region, err := cmd.Flags().GetString(regionFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		prefix, err := cmd.Flags().GetString(prefixFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		keyDir, err := cmd.Flags().GetString(keyDirFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		keyBits, err := cmd.Flags().GetInt(keyBitsFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		pkiDir, err := cmd.Flags().GetString(pkiDirFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		audiences, err := cmd.Flags().GetStringSlice(apiAudiencesFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		s3Options, err := publish.S3OptionsFromFlags(cmd.Flags(), profile)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		s3Options.Bucket, s3Options.Region = bucket, region
+		if region == "" && s3Options.Endpoint == "" {
+			log.Error(fmt.Errorf("--%s is required", regionFlag))
+			os.Exit(1)
+		}
+		// bootstrap publishes at the URL of the bucket, while a distribution
+		// has its own, which is only known once it exists
+		if s3Options.Publish == aws.PublishCloudFront {
+			log.Error(fmt.Errorf("--%s %s is not supported by bootstrap, which publishes at the URL of the bucket; use %s or %s",
+				publish.PublishModeFlag, aws.PublishCloudFront, aws.PublishACL, aws.PublishBucketPolicy))
+			os.Exit(1)
+		}
+		create, err := cmd.Flags().GetBool(createOidcProviderFlag)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		pub, _, err := oidc.LoadOrGenerateSigningKey(filepath.Join(keyDir, signingKeyFile), filepath.Join(keyDir, publicKeyFile), keyBits)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		issuerURL := aws.S3IssuerURL(bucket, region, prefix)
+		if s3Options.Endpoint != "" {
+			issuerURL, err = aws.S3EndpointIssuerURL(s3Options.Endpoint, bucket, prefix, s3Options.PathStyle)
+			if err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		}
+		log.Info("Issuer URL is %s", issuerURL)
+		issuer, err := oidc.NewIssuer(issuerURL, []crypto.PublicKey{pub})
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		keyPrefix := publish.IssuerPrefix(prefix)
+		upload, err := aws.UploadToS3(s3Options,
+			keyPrefix+oidc.DiscoveryPath, string(issuer.RawDiscovery), keyPrefix+oidc.JWKSPath, string(issuer.RawJWKS))
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		if _, err := publish.RecordHistory(s3Options, keyPrefix, issuer, publish.Hash(issuer.RawJWKS), upload, ""); err != nil {
+			log.Info("Published, but can't record history: %v", err)
+		}
+		if create {
+			if err := aws.CreateOIDCProvider(profile, issuerURL); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		}
+		flags := &apiserver.Options{
+			Issuer:         issuerURL,
+			Audiences:      audiences,
+			SigningKeyFile: filepath.ToSlash(filepath.Join(pkiDir, signingKeyFile)),
+			KeyFiles:       []string{filepath.ToSlash(filepath.Join(pkiDir, publicKeyFile))},
+		}
+		log.Instructions("Copy %s and %s to %s on every control plane node and start kube-apiserver with:\n\n  %s",
+			filepath.Join(keyDir, signingKeyFile), filepath.Join(keyDir, publicKeyFile), pkiDir, strings.Join(flags.Args(), " \\\n  "))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(bootstrapCmd)
+	bootstrapCmd.Flags().String(bucketFlag, "", "S3 bucket to publish oidc config to, created if missing")
+	bootstrapCmd.Flags().String(regionFlag, "", "AWS region of the bucket, optional with --s3-endpoint")
+	bootstrapCmd.Flags().String(prefixFlag, "", "Path in the bucket, e.g. the cluster name, to share a bucket between clusters")
+	bootstrapCmd.Flags().String(keyDirFlag, ".", "Directory to write "+signingKeyFile+" and "+publicKeyFile+" to; an existing "+signingKeyFile+" is reused")
+	bootstrapCmd.Flags().Int(keyBitsFlag, oidc.DefaultKeyBits, "Size of the generated RSA signing key")
+	bootstrapCmd.Flags().String(pkiDirFlag, "/etc/kubernetes/pki", "Directory the keys are copied to on the control plane nodes")
+	bootstrapCmd.Flags().StringSlice(apiAudiencesFlag, nil, "--api-audiences of the API server, defaults to the issuer URL")
+	publish.AddS3Flags(bootstrapCmd.Flags())
+	bootstrapCmd.Flags().Bool(createOidcProviderFlag, true, "Create OIDC provider in IAM")
+	bootstrapCmd.MarkFlagRequired(bucketFlag)
+}
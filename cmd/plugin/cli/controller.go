@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/controller"
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/metrics"
+	"github.com/shundezhang/oidc-config/pkg/publish"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	syncIntervalFlag     = "sync-interval"
+	updateThumbprintFlag = "update-thumbprint"
+	verifyPublishedFlag  = "verify-published"
+	metricsAddrFlag      = "metrics-addr"
+)
+
+var controllerCmd = &cobra.Command{
+	Use:   "controller",
+	Short: "keep published oidc config and jwks in sync with the cluster",
+	Long: `poll the jwks of the API server and republish config and jwks when the key set changes,
+and keep the thumbprint of the OIDC provider in IAM up to date. Runs in a pod with in-cluster config,
+see deploy-controller`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		c := &controller.Controller{}
+		interval, err := cmd.Flags().GetDuration(syncIntervalFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if c.IssuerURL, err = cmd.Flags().GetString(issuerURLFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if c.UpdateThumbprint, err = cmd.Flags().GetBool(updateThumbprintFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if c.Verify, err = cmd.Flags().GetBool(verifyPublishedFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if c.WebhookNamespace, err = cmd.Flags().GetString(webhookNamespaceFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if c.WebhookName, err = cmd.Flags().GetString(webhookNameFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		metricsAddr, err := cmd.Flags().GetString(metricsAddrFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if c.Profile, err = cmd.Flags().GetString(awsProfile); err != nil {
+			log.Error(err)
+			return
+		}
+		names, err := cmd.Flags().GetStringSlice(publisherFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if len(names) == 0 {
+			log.Error(fmt.Errorf("--%s is required, use one or more of %s", publisherFlag, strings.Join(publish.Names(), ", ")))
+			return
+		}
+		c.Publishers, err = newPublishers(cmd.Flags(), names, publish.Config{Profile: c.Profile, Getter: KubernetesConfigFlags})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		// the same cluster the publishers write to, the in-cluster config
+		// when running in a pod
+		if c.Config, err = k8s.GetKubernetesConfig(KubernetesConfigFlags); err != nil {
+			log.Error(err)
+			return
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if metricsAddr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			srv := &http.Server{Addr: metricsAddr, Handler: mux}
+			go func() {
+				if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Error(err)
+					os.Exit(1)
+				}
+			}()
+			defer srv.Close()
+			log.Info("Serving metrics on %s/metrics", metricsAddr)
+		}
+		log.Info("Syncing every %s with publishers %v", interval, names)
+		c.Run(ctx, interval)
+	},
+}
+
+// addControllerFlags adds the flags of the controller command, which
+// deploy-controller passes on to it.
+func addControllerFlags(fs *pflag.FlagSet) {
+	fs.Duration(syncIntervalFlag, time.Minute, "How often the jwks of the API server is checked for changes")
+	fs.String(issuerURLFlag, "", "Public issuer URL; issuer and jwks_uri in the published config are rewritten to it")
+	fs.Bool(updateThumbprintFlag, false, "Update the thumbprint of the OIDC provider in IAM when the certificate of the issuer changes")
+	fs.Bool(verifyPublishedFlag, true, "Check after each sync that the jwks at the public issuer URL has every key of the cluster")
+	fs.String(metricsAddrFlag, ":8080", "Address to serve Prometheus metrics on at /metrics, disabled if empty")
+	fs.String(webhookNamespaceFlag, "default", "Namespace of the pod identity webhook deployment")
+	fs.String(webhookNameFlag, "", "Name of the pod identity webhook deployment and mutatingwebhookconfiguration to report the availability of, e.g. pod-identity-webhook")
+	fs.StringSlice(publisherFlag, nil, fmt.Sprintf("Publish config and jwks with these publishers, in order: %s", strings.Join(publish.Names(), ", ")))
+	publish.AddFlags(fs)
+}
+
+func init() {
+	rootCmd.AddCommand(controllerCmd)
+	addControllerFlags(controllerCmd.Flags())
+}
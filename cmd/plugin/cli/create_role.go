@@ -1,14 +1,17 @@
 package cli
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/url"
+	"os"
+	"strings"
 
 	"github.com/shundezhang/oidc-config/pkg/aws"
 	"github.com/shundezhang/oidc-config/pkg/k8s"
 	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
 	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 const (
@@ -18,114 +21,179 @@ const (
 	policyName      = "policy-name"
 	createSAFlag    = "create-sa"
 	allowAllSAsFlag = "allow-all-sas"
+
+	// contextPlaceholder in --role-name is replaced with the name of the
+	// context in batch mode, as role names are unique in an account.
+	contextPlaceholder = "{context}"
 )
 
+type createRoleOptions struct {
+	role        string
+	profile     string
+	namespace   string
+	sa          string
+	policy      string
+	createSA    bool
+	allowAllSAs bool
+}
+
+// createRoleResult is what create-role reports for each context in batch mode.
+type createRoleResult struct {
+	RoleName        string `json:"roleName" yaml:"roleName"`
+	RoleARN         string `json:"roleARN" yaml:"roleARN"`
+	OIDCProviderARN string `json:"oidcProviderARN" yaml:"oidcProviderARN"`
+	Namespace       string `json:"namespace" yaml:"namespace"`
+	ServiceAccount  string `json:"serviceAccount,omitempty" yaml:"serviceAccount,omitempty"`
+}
+
+func (r *createRoleResult) String() string {
+	return fmt.Sprintf("role %s for namespace %s", r.RoleARN, r.Namespace)
+}
+
 var createRoleCmd = &cobra.Command{
 	Use:   "create-role",
 	Short: "create a role in IAM and a service account in k8s",
 	Long:  `create a role in IAM and a service account in k8s`,
 	Run: func(cmd *cobra.Command, args []string) {
 		log := logger.NewLogger()
-		// configPath, err := cmd.Flags().GetString(kubeConfigPath)
-		// if err != nil {
-		// 	return
-		// }
-		role, err := cmd.Flags().GetString(roleName)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-		configPath, err := cmd.Flags().GetString(kubeConfigPath)
-		if err != nil {
-			log.Error(err)
-			return
-		}
-		profile, err := cmd.Flags().GetString(awsProfile)
+		o := &createRoleOptions{}
+		var err error
+		o.role, err = cmd.Flags().GetString(roleName)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		ns, err := cmd.Flags().GetString(saNameSpace)
+		o.profile, err = cmd.Flags().GetString(awsProfile)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		sa, err := cmd.Flags().GetString(saName)
+		o.namespace, err = cmd.Flags().GetString(saNameSpace)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		policy, err := cmd.Flags().GetString(policyName)
+		o.sa, err = cmd.Flags().GetString(saName)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		createSA, err := cmd.Flags().GetBool(createSAFlag)
+		o.policy, err = cmd.Flags().GetString(policyName)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		allowAllSAs, err := cmd.Flags().GetBool(allowAllSAsFlag)
+		o.createSA, err = cmd.Flags().GetBool(createSAFlag)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		c, err := k8s.GetKubernetesConfig(configPath)
+		o.allowAllSAs, err = cmd.Flags().GetBool(allowAllSAsFlag)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		config, err := k8s.GetURL(c.Host+"/.well-known/openid-configuration", c.BearerToken, c.CAData)
+		contexts, err := batchContexts(cmd)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		var objmap map[string]interface{}
-		if err := json.Unmarshal([]byte(config), &objmap); err != nil {
-			log.Error(err)
-		}
-		u, err := url.Parse(fmt.Sprintf("%v", objmap["issuer"]))
-		if err != nil {
-			log.Error(err)
+		if len(contexts) > 0 {
+			output, err := cmd.Flags().GetString(outputFormat)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			parallel, err := cmd.Flags().GetInt(parallelFlag)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if !strings.Contains(o.role, contextPlaceholder) {
+				log.Error(fmt.Errorf("--%s must contain %s with --%s or --%s, e.g. %s-%s, as every cluster needs its own role", roleName, contextPlaceholder, allContextsFlag, contextsFlag, o.role, contextPlaceholder))
+				return
+			}
+			slugs, err := contextSlugs(contexts)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			results := runBatch(contexts, parallel, func(name string, getter genericclioptions.RESTClientGetter) (interface{}, error) {
+				co := *o
+				co.role = strings.ReplaceAll(o.role, contextPlaceholder, slugs[name])
+				return co.run(getter)
+			})
+			if err := printBatchReport(results, output); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
 			return
 		}
-		arn, err := aws.GetOIDCProviderARN(profile, u.Hostname()+u.Path)
-		if err != nil {
+		if _, err := o.run(KubernetesConfigFlags); err != nil {
 			log.Error(err)
 			return
 		}
-		allowedSA := sa
-		if allowAllSAs {
-			allowedSA = "*"
-		}
-		policyArn, err := aws.GetPolicyARN(profile, policy)
+	},
+}
+
+func (o *createRoleOptions) run(getter genericclioptions.RESTClientGetter) (*createRoleResult, error) {
+	ns := o.namespace
+	if ns == "" {
+		var err error
+		ns, _, err = getter.ToRawKubeConfigLoader().Namespace()
 		if err != nil {
-			log.Error(err)
-			return
-		}
-		roleArn, err := aws.CreateRole(profile, role, policyArn, arn, ns, allowedSA)
+			return nil, err
+		}
+	}
+	c, err := k8s.GetKubernetesConfig(getter)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := oidc.Fetch(context.Background(), c)
+	if err != nil {
+		return nil, err
+	}
+	u, err := issuer.Discovery.IssuerURL()
+	if err != nil {
+		return nil, err
+	}
+	arn, err := aws.GetOIDCProviderARN(o.profile, u.Hostname()+u.Path)
+	if err != nil {
+		return nil, err
+	}
+	allowedSA := o.sa
+	if o.allowAllSAs {
+		allowedSA = "*"
+	}
+	policyArn, err := aws.GetPolicyARN(o.profile, o.policy)
+	if err != nil {
+		return nil, err
+	}
+	roleArn, err := aws.CreateRole(o.profile, o.role, policyArn, arn, ns, allowedSA)
+	if err != nil {
+		return nil, err
+	}
+	result := &createRoleResult{RoleName: o.role, RoleARN: roleArn, OIDCProviderARN: arn, Namespace: ns}
+	if o.createSA {
+		err := k8s.CreateSA(getter, o.sa, ns, roleArn)
 		if err != nil {
-			log.Error(err)
-			return
+			return nil, err
 		}
-		if createSA {
-			err := k8s.CreateSA(configPath, sa, ns, roleArn)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-		}
-	},
+		result.ServiceAccount = o.sa
+	}
+	return result, nil
 }
 
 func init() {
 	rootCmd.AddCommand(createRoleCmd)
-	createRoleCmd.Flags().StringP(roleName, "r", "", "role name")
+	createRoleCmd.Flags().StringP(roleName, "r", "", "role name; with --all-contexts or --contexts it must contain "+contextPlaceholder+", which is replaced with the name of each context")
 	createRoleCmd.Flags().StringP(policyName, "p", "", "policy name")
 	createRoleCmd.Flags().String(saName, "my-sa", "sa name")
-	createRoleCmd.Flags().String(saNameSpace, "default", "sa namespace")
+	createRoleCmd.Flags().String(saNameSpace, "", "sa namespace, defaults to the namespace of the current context")
 	createRoleCmd.Flags().Bool(createSAFlag, false, "Create SA for this role")
 	createRoleCmd.Flags().Bool(allowAllSAsFlag, true, "Allow all SAs in the namespace to use this role, otherwise only the created SA can use.")
+	createRoleCmd.Flags().StringP(outputFormat, "o", "", "output format of the batch report: table, yaml or json")
+	addBatchFlags(createRoleCmd)
 	createRoleCmd.MarkFlagRequired(roleName)
 	createRoleCmd.MarkFlagRequired(policyName)
 }
@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/controller"
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/publish"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+const (
+	controllerNamespaceFlag = "controller-namespace"
+	controllerSAFlag        = "controller-sa"
+	controllerImageFlag     = "image"
+	roleARNFlag             = "role-arn"
+)
+
+var deployControllerCmd = &cobra.Command{
+	Use:   "deploy-controller",
+	Short: "deploy the controller that keeps published oidc config in sync",
+	Long: `deploy the controller with its RBAC and a service account for IRSA. Flags of the controller
+command, such as --publisher, are passed on to it`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		opts := controller.DeployOptions{}
+		var err error
+		if opts.Namespace, err = cmd.Flags().GetString(controllerNamespaceFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if opts.ServiceAccount, err = cmd.Flags().GetString(controllerSAFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if opts.Image, err = cmd.Flags().GetString(controllerImageFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		if opts.RoleARN, err = cmd.Flags().GetString(roleARNFlag); err != nil {
+			log.Error(err)
+			return
+		}
+		output, err := cmd.Flags().GetString(outputFormat)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		names, err := cmd.Flags().GetStringSlice(publisherFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if opts.Image == "" {
+			log.Error(fmt.Errorf("--%s is required, build it with the Dockerfile in the repository", controllerImageFlag))
+			return
+		}
+		if len(names) == 0 {
+			log.Error(fmt.Errorf("--%s is required, use one or more of %s", publisherFlag, strings.Join(publish.Names(), ", ")))
+			return
+		}
+		for _, name := range names {
+			switch name {
+			case publish.ConfigMap:
+				opts.ConfigMaps = true
+			case publish.Nginx:
+				opts.ConfigMaps, opts.Workloads = true, true
+			}
+		}
+		webhook, err := cmd.Flags().GetString(webhookNameFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		opts.Webhook = webhook != ""
+		metricsAddr, err := cmd.Flags().GetString(metricsAddrFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if metricsAddr != "" {
+			_, port, err := net.SplitHostPort(metricsAddr)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			if opts.MetricsPort, err = strconv.Atoi(port); err != nil {
+				log.Error(fmt.Errorf("--%s needs a numeric port: %v", metricsAddrFlag, err))
+				return
+			}
+		}
+		opts.Args = controllerArgs(cmd.Flags())
+		content, err := controller.Render(opts)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if output == "yaml" {
+			fmt.Print(string(content))
+			return
+		}
+		if err := k8s.Apply(KubernetesConfigFlags, content); err != nil {
+			log.Error(err)
+			return
+		}
+		log.Info("Deployed the controller to namespace %s", opts.Namespace)
+	},
+}
+
+// controllerArgs returns the flags of the controller command given on the
+// command line, as arguments for the controller.
+func controllerArgs(fs *pflag.FlagSet) []string {
+	known := pflag.NewFlagSet("controller", pflag.ContinueOnError)
+	addControllerFlags(known)
+	args := []string{}
+	fs.Visit(func(f *pflag.Flag) {
+		if known.Lookup(f.Name) == nil {
+			return
+		}
+		value := f.Value.String()
+		if s, ok := f.Value.(pflag.SliceValue); ok {
+			value = strings.Join(s.GetSlice(), ",")
+		}
+		args = append(args, fmt.Sprintf("--%s=%s", f.Name, value))
+	})
+	return args
+}
+
+func init() {
+	rootCmd.AddCommand(deployControllerCmd)
+	deployControllerCmd.Flags().String(controllerNamespaceFlag, "oidc-config", "Namespace to deploy the controller to")
+	deployControllerCmd.Flags().String(controllerSAFlag, "oidc-config-controller", "Service account of the controller")
+	deployControllerCmd.Flags().String(controllerImageFlag, "", "Image of oidc-config to run the controller with")
+	deployControllerCmd.Flags().String(roleARNFlag, "", "IAM role for the controller to publish with, set on its service account for IRSA")
+	deployControllerCmd.Flags().StringP(outputFormat, "o", "", "yaml prints the manifests instead of applying them")
+	addControllerFlags(deployControllerCmd.Flags())
+}
@@ -32,18 +32,13 @@ var deployWebhookCmd = &cobra.Command{
 			log.Error(err)
 			return
 		}
-		configPath, err := cmd.Flags().GetString(kubeConfigPath)
-		if err != nil {
-			log.Error(err)
-			return
-		}
 		log.Info("Getting YAML from %s", cm)
 		content, err := k8s.GetYAML(cm)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		err = k8s.Apply(configPath, content)
+		err = k8s.Apply(KubernetesConfigFlags, content)
 		if err != nil {
 			log.Error(err)
 			return
@@ -58,7 +53,7 @@ var deployWebhookCmd = &cobra.Command{
 			if webhookFiles[i] == "deployment-base.yaml" {
 				content = []byte(strings.ReplaceAll(string(content), "IMAGE", "amazon/amazon-eks-pod-identity-webhook:latest"))
 			}
-			err = k8s.Apply(configPath, content)
+			err = k8s.Apply(KubernetesConfigFlags, content)
 			if err != nil {
 				log.Error(err)
 				return
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/shundezhang/oidc-config/pkg/doctor"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+const (
+	webhookNamespaceFlag = "webhook-namespace"
+	webhookNameFlag      = "webhook-name"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "check the whole chain from API server issuer to IAM role",
+	Long: `check the API server issuer, the published oidc config and jwks, the oidc provider in IAM,
+the pod identity webhook and optionally the role of a service account, and print what to fix`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		o := doctor.Options{Getter: KubernetesConfigFlags}
+		var err error
+		o.Profile, err = cmd.Flags().GetString(awsProfile)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.WebhookNamespace, err = cmd.Flags().GetString(webhookNamespaceFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.WebhookName, err = cmd.Flags().GetString(webhookNameFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.ServiceAccount, err = cmd.Flags().GetString(saName)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.Namespace, err = cmd.Flags().GetString(saNameSpace)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if o.Namespace == "" {
+			o.Namespace, _, err = KubernetesConfigFlags.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		}
+		report := doctor.Run(context.Background(), o)
+		for _, r := range report {
+			fmt.Printf("[%s] %-24s %s\n", r.Status, r.Name, r.Message)
+			if r.Status != doctor.Pass && r.Hint != "" {
+				fmt.Printf("       %-24s hint: %s\n", "", r.Hint)
+			}
+		}
+		if report.Failed() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().String(webhookNamespaceFlag, "default", "namespace of the pod identity webhook deployment")
+	doctorCmd.Flags().String(webhookNameFlag, "pod-identity-webhook", "name of the pod identity webhook deployment and mutatingwebhookconfiguration")
+	doctorCmd.Flags().String(saName, "", "sa name whose role annotation and trust policy are checked")
+	doctorCmd.Flags().String(saNameSpace, "", "sa namespace, defaults to the namespace of the current context")
+}
@@ -1,23 +1,31 @@
 package cli
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 
-	"net/url"
-
 	"github.com/shundezhang/oidc-config/pkg/aws"
 	"github.com/shundezhang/oidc-config/pkg/k8s"
 	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/publish"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"gopkg.in/yaml.v3"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
 const (
 	outputFormat           = "output"
 	uploadFlag             = "upload-to-s3"
 	createOidcProviderFlag = "create-oidc-provider"
+	validateFlag           = "validate"
+	issuerURLFlag          = "issuer-url"
+	fromPublicKeyFlag      = "from-public-key"
+	publisherFlag          = "publisher"
 )
 
 type Oidc struct {
@@ -27,6 +35,32 @@ type Oidc struct {
 	jwksContent   string
 }
 
+type getOptions struct {
+	output    string
+	create    bool
+	profile   string
+	validate  bool
+	issuerURL string
+	keyFiles  []string
+	// publishers are the names of the publishers to run in order, created
+	// from flags.
+	publishers []string
+	flags      *pflag.FlagSet
+}
+
+// getResult is what get reports for each context in batch mode.
+type getResult struct {
+	Issuer     string   `json:"issuer" yaml:"issuer"`
+	JWKSURI    string   `json:"jwksURI" yaml:"jwksURI"`
+	KeyIDs     []string `json:"keyIDs" yaml:"keyIDs"`
+	Publishers []string `json:"publishers" yaml:"publishers"`
+	Created    bool     `json:"oidcProviderCreated" yaml:"oidcProviderCreated"`
+}
+
+func (r *getResult) String() string {
+	return fmt.Sprintf("issuer %s, %d keys", r.Issuer, len(r.KeyIDs))
+}
+
 var getCmd = &cobra.Command{
 	Use:   "get",
 	Short: "get oidc config content, upload to s3 and create oidc provider",
@@ -34,69 +68,129 @@ var getCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		log := logger.NewLogger()
 		// log.Info("")
-		configPath, err := cmd.Flags().GetString(kubeConfigPath)
+		o := &getOptions{}
+		var err error
+		o.output, err = cmd.Flags().GetString(outputFormat)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		output, err := cmd.Flags().GetString(outputFormat)
+		o.create, err = cmd.Flags().GetBool(createOidcProviderFlag)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		upload, err := cmd.Flags().GetBool(uploadFlag)
+		o.profile, err = cmd.Flags().GetString(awsProfile)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		create, err := cmd.Flags().GetBool(createOidcProviderFlag)
+		o.validate, err = cmd.Flags().GetBool(validateFlag)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		profile, err := cmd.Flags().GetString(awsProfile)
+		o.issuerURL, err = cmd.Flags().GetString(issuerURLFlag)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		c, err := k8s.GetKubernetesConfig(configPath)
+		o.keyFiles, err = cmd.Flags().GetStringSlice(fromPublicKeyFlag)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		config, err := k8s.GetURL(c.Host+"/.well-known/openid-configuration", c.BearerToken, c.CAData)
+		o.flags = cmd.Flags()
+		o.publishers, err = selectPublishers(cmd.Flags())
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		var objmap map[string]interface{}
-		if err := json.Unmarshal([]byte(config), &objmap); err != nil {
+		// check the publisher flags before talking to the cluster
+		if _, err := o.newPublishers(KubernetesConfigFlags); err != nil {
 			log.Error(err)
+			return
 		}
-		jwks, err := k8s.GetURL(c.Host+"/openid/v1/jwks", c.BearerToken, c.CAData)
+		if len(o.keyFiles) > 0 && o.issuerURL == "" {
+			log.Error(fmt.Errorf("--%s requires --%s", fromPublicKeyFlag, issuerURLFlag))
+			return
+		}
+		contexts, err := batchContexts(cmd)
 		if err != nil {
 			log.Error(err)
 			return
 		}
-		if output == "" {
-			fmt.Println(objmap["issuer"])
+		if len(contexts) > 0 {
+			if mode, _ := cmd.Flags().GetString(publish.PublishModeFlag); mode == aws.PublishCloudFront {
+				log.Error(fmt.Errorf("--%s %s publishes a single issuer and can't be used with --%s or --%s", publish.PublishModeFlag, aws.PublishCloudFront, allContextsFlag, contextsFlag))
+				return
+			}
+			for _, name := range o.publishers {
+				if name == publish.GCS {
+					log.Error(fmt.Errorf("publisher %s publishes a single issuer and can't be used with --%s or --%s", publish.GCS, allContextsFlag, contextsFlag))
+					return
+				}
+			}
+			if len(o.keyFiles) > 0 {
+				log.Error(fmt.Errorf("--%s does not talk to a cluster and can't be used with --%s or --%s", fromPublicKeyFlag, allContextsFlag, contextsFlag))
+				return
+			}
+			parallel, err := cmd.Flags().GetInt(parallelFlag)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			results := runBatch(contexts, parallel, o.runBatch)
+			if err := printBatchReport(results, o.output); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+			return
+		}
+		issuer, err := o.fetch(KubernetesConfigFlags)
+		if err != nil {
+			log.Error(err)
+			if o.validate {
+				os.Exit(1)
+			}
+			return
+		}
+		var report oidc.Report
+		if o.validate {
+			target, err := o.target(KubernetesConfigFlags)
+			if err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+			report = issuer.Validate(target)
+		}
+		config, jwks := issuer.RawDiscovery, issuer.RawJWKS
+		if o.output == "" {
+			fmt.Println(issuer.Discovery.Issuer)
 			fmt.Println(string(config))
-			fmt.Println(objmap["jwks_uri"])
+			fmt.Println(issuer.Discovery.JWKSURI)
 			fmt.Println(string(jwks))
+			if o.validate {
+				printReport(report)
+			}
 		} else {
 			outmap := make(map[string]interface{})
-			outmap["configURL"] = fmt.Sprintf("%v/.well-known/openid-configuration", objmap["issuer"])
+			outmap["configURL"] = issuer.Discovery.Issuer + oidc.DiscoveryPath
 			outmap["configContent"] = string(config)
-			outmap["jwksURL"] = objmap["jwks_uri"]
+			outmap["jwksURL"] = issuer.Discovery.JWKSURI
 			outmap["jwksContent"] = string(jwks)
-			if output == "json" {
+			if o.validate {
+				// in the document, so that the output stays parseable
+				outmap["validation"] = report
+			}
+			if o.output == "json" {
 				b, err := json.MarshalIndent(outmap, "", "  ")
 				if err != nil {
 					log.Error(err)
 					return
 				}
 				fmt.Println(string(b))
-			} else if output == "yaml" {
+			} else if o.output == "yaml" {
 				out, err := yaml.Marshal(outmap)
 				if err != nil {
 					log.Error(err)
@@ -104,39 +198,216 @@ var getCmd = &cobra.Command{
 
 				fmt.Println(string(out))
 			} else {
-				log.Info("output format %s not supported.", output)
+				log.Info("output format %s not supported.", o.output)
 			}
 		}
-		if upload {
-			u, err := url.Parse(fmt.Sprintf("%v", objmap["issuer"]))
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			if !strings.HasSuffix(u.Hostname(), "s3.amazonaws.com") {
-				fmt.Println("URL is not an S3 URL")
-				return
-			}
-			bucket := strings.Split(u.Hostname(), ".")[0]
-			err1 := aws.UploadToS3(profile, bucket, u.Path+"/.well-known/openid-configuration", string(config), u.Path+"/openid/v1/jwks", string(jwks))
-			if err1 != nil {
-				log.Error(err1)
-				return
-			}
+		if o.validate && !report.Passed() {
+			os.Exit(1)
 		}
-		if create {
-			err1 := aws.CreateOIDCProvider(profile, fmt.Sprintf("%v", objmap["issuer"]))
-			if err1 != nil {
-				log.Error(err1)
-				return
-			}
+		if _, err := o.publish(KubernetesConfigFlags, issuer); err != nil {
+			log.Error(err)
+			os.Exit(1)
 		}
 	},
 }
 
+// fetch gets the config and jwks from the cluster, rewritten to --issuer-url
+// if given. With --from-public-key they are built locally instead.
+func (o *getOptions) fetch(getter genericclioptions.RESTClientGetter) (*oidc.Issuer, error) {
+	if len(o.keyFiles) > 0 {
+		keys, err := oidc.PublicKeysFromFiles(o.keyFiles)
+		if err != nil {
+			return nil, err
+		}
+		return oidc.NewIssuer(o.issuerURL, keys)
+	}
+	c, err := k8s.GetKubernetesConfig(getter)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := oidc.Fetch(context.Background(), c)
+	if err != nil {
+		return nil, err
+	}
+	if o.issuerURL != "" {
+		if err := issuer.CheckTokenIssuer(o.issuerURL); err != nil {
+			return nil, err
+		}
+		return issuer.Rewrite(o.issuerURL)
+	}
+	return issuer, nil
+}
+
+// selectPublishers returns the publishers selected by --publisher, and by
+// --upload-to-s3, --out-dir and --publish-mode cloudfront, which select the
+// s3 and dir publishers by themselves.
+func selectPublishers(fs *pflag.FlagSet) ([]string, error) {
+	names, err := fs.GetStringSlice(publisherFlag)
+	if err != nil {
+		return nil, err
+	}
+	upload, err := fs.GetBool(uploadFlag)
+	if err != nil {
+		return nil, err
+	}
+	outDir, err := fs.GetString(publish.OutDirFlag)
+	if err != nil {
+		return nil, err
+	}
+	mode, err := fs.GetString(publish.PublishModeFlag)
+	if err != nil {
+		return nil, err
+	}
+	if outDir != "" {
+		names = append([]string{publish.Dir}, names...)
+	}
+	// the issuer URL is only known once the distribution exists
+	if upload || mode == aws.PublishCloudFront {
+		names = append(names, publish.S3)
+	}
+	selected := []string{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if _, err := publish.Lookup(name); err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			selected = append(selected, name)
+		}
+	}
+	return selected, nil
+}
+
+func (o *getOptions) newPublishers(getter genericclioptions.RESTClientGetter) ([]publish.Publisher, error) {
+	return newPublishers(o.flags, o.publishers, publish.Config{Profile: o.profile, Getter: getter})
+}
+
+// newPublishers creates the named publishers from their flags.
+func newPublishers(fs *pflag.FlagSet, names []string, c publish.Config) ([]publish.Publisher, error) {
+	publishers := []publish.Publisher{}
+	for _, name := range names {
+		f, err := publish.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		p, err := f.New(fs, c)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, p)
+	}
+	return publishers, nil
+}
+
+// target returns the issuer URL the config is published at, --issuer-url or
+// the URL a publisher decides, or empty if that is the issuer of the cluster.
+func (o *getOptions) target(getter genericclioptions.RESTClientGetter) (string, error) {
+	if o.issuerURL != "" {
+		return o.issuerURL, nil
+	}
+	publishers, err := o.newPublishers(getter)
+	if err != nil {
+		return "", err
+	}
+	for _, p := range publishers {
+		if t, ok := p.(publish.Targeter); ok && t.Target() != "" {
+			return t.Target(), nil
+		}
+	}
+	return "", nil
+}
+
+// publish runs the selected publishers and creates the OIDC provider for the
+// issuer as published.
+func (o *getOptions) publish(getter genericclioptions.RESTClientGetter, issuer *oidc.Issuer) (*oidc.Issuer, error) {
+	publishers, err := o.newPublishers(getter)
+	if err != nil {
+		return nil, err
+	}
+	if len(publishers) > 0 || o.create {
+		if err := issuer.Check(); err != nil {
+			return nil, fmt.Errorf("not publishing: %v", err)
+		}
+	}
+	for _, p := range publishers {
+		issuer, err = p.Publish(context.Background(), issuer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.create {
+		err := aws.CreateOIDCProvider(o.profile, issuer.Discovery.Issuer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return issuer, nil
+}
+
+// runBatch runs get against one context of a batch.
+func (o *getOptions) runBatch(_ string, getter genericclioptions.RESTClientGetter) (interface{}, error) {
+	issuer, err := o.fetch(getter)
+	if err != nil {
+		return nil, err
+	}
+	if o.validate {
+		target, err := o.target(getter)
+		if err != nil {
+			return nil, err
+		}
+		failed := []string{}
+		for _, r := range issuer.Validate(target) {
+			if !r.Passed {
+				failed = append(failed, r.Message)
+			}
+		}
+		if len(failed) > 0 {
+			return nil, fmt.Errorf("validation failed: %s", strings.Join(failed, "; "))
+		}
+	}
+	issuer, err = o.publish(getter, issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &getResult{
+		Issuer:     issuer.Discovery.Issuer,
+		JWKSURI:    issuer.Discovery.JWKSURI,
+		KeyIDs:     issuer.JWKS.KeyIDs(),
+		Publishers: o.publishers,
+		Created:    o.create,
+	}, nil
+}
+
 func init() {
 	rootCmd.AddCommand(getCmd)
 	getCmd.Flags().StringP(outputFormat, "o", "", "output format: default, yaml or json")
-	getCmd.Flags().Bool(uploadFlag, false, "Upload config and jwks to s3 bucket")
+	getCmd.Flags().Bool(uploadFlag, false, "Upload config and jwks to s3 bucket, same as --publisher s3")
 	getCmd.Flags().Bool(createOidcProviderFlag, false, "Create OIDC provider in IAM")
+	getCmd.Flags().String(issuerURLFlag, "", "Public issuer URL; issuer and jwks_uri in the published config are rewritten to it")
+	getCmd.Flags().StringSlice(fromPublicKeyFlag, nil, "Build config and jwks from these PEM public key files, e.g. the API server's --service-account-key-file, instead of getting them from the cluster. Requires --issuer-url")
+	getCmd.Flags().Bool(validateFlag, false, "Validate config and jwks against OIDC discovery and IAM requirements, exit non-zero on failure")
+	getCmd.Flags().StringSlice(publisherFlag, nil, fmt.Sprintf("Publish config and jwks with these publishers, in order: %s", strings.Join(publish.Names(), ", ")))
+	publish.AddFlags(getCmd.Flags())
+	addBatchFlags(getCmd)
+	getCmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		// --issuer reads better with --from-public-key
+		switch name {
+		case "issuer":
+			name = issuerURLFlag
+		case "publish":
+			name = publish.PublishModeFlag
+		}
+		return pflag.NormalizedName(name)
+	})
+}
+
+func printReport(report oidc.Report) {
+	for _, r := range report {
+		status := "PASS"
+		if !r.Passed {
+			status = "FAIL"
+		}
+		fmt.Printf("[%s] %-16s %s\n", status, r.Name, r.Message)
+	}
 }
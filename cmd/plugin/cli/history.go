@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/publish"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "list and roll back config and jwks published to S3",
+	Long: `every publish to S3 records the config and jwks with the kids and a hash of the jwks of the cluster,
+as a copy or, in a versioned bucket, the versions of the objects. list shows them and rollback publishes one again`,
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list config and jwks published to S3, the latest first",
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		output, err := cmd.Flags().GetString(outputFormat)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		opts, prefix, err := historyLocation(cmd)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		entries, err := publish.ListHistory(opts, prefix)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		switch output {
+		case "json":
+			b, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			fmt.Println(string(b))
+		case "yaml":
+			b, err := yaml.Marshal(entries)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			fmt.Print(string(b))
+		case "", "table":
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tPUBLISHED\tKIDS\tCLUSTER\tROLLBACK OF")
+			for _, e := range entries {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.ID, e.Time.Local().Format(time.RFC3339), strings.Join(e.KeyIDs, ","), shortHash(e.ClusterHash), e.RollbackOf)
+			}
+			w.Flush()
+		default:
+			log.Error(fmt.Errorf("output format %s not supported", output))
+		}
+	},
+}
+
+var historyRollbackCmd = &cobra.Command{
+	Use:   "rollback <id>",
+	Short: "publish the config and jwks of a history entry again",
+	Long: `publish the config and jwks of a history entry from history list again, and record that as a new entry.
+A running controller publishes the keys of the cluster again once they change`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		opts, prefix, err := historyLocation(cmd)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		entry, err := publish.Rollback(opts, prefix, args[0])
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		log.Info("Rolled back to %s with keys %v, recorded as %s", args[0], entry.KeyIDs, entry.ID)
+	},
+}
+
+// historyLocation returns the bucket and path the issuer is published at,
+// given by --bucket or the issuer URL, which defaults to the issuer of the
+// cluster.
+func historyLocation(cmd *cobra.Command) (aws.S3Options, string, error) {
+	profile, err := cmd.Flags().GetString(awsProfile)
+	if err != nil {
+		return aws.S3Options{}, "", err
+	}
+	opts, err := publish.S3OptionsFromFlags(cmd.Flags(), profile)
+	if err != nil {
+		return opts, "", err
+	}
+	bucket, err := cmd.Flags().GetString(bucketFlag)
+	if err != nil {
+		return opts, "", err
+	}
+	if bucket != "" {
+		opts.Bucket = bucket
+		if opts.Region, err = cmd.Flags().GetString(regionFlag); err != nil {
+			return opts, "", err
+		}
+		prefix, err := cmd.Flags().GetString(prefixFlag)
+		if err != nil {
+			return opts, "", err
+		}
+		return opts, publish.IssuerPrefix(prefix), nil
+	}
+	issuerURL, err := cmd.Flags().GetString(issuerURLFlag)
+	if err != nil {
+		return opts, "", err
+	}
+	if issuerURL == "" {
+		c, err := k8s.GetKubernetesConfig(KubernetesConfigFlags)
+		if err != nil {
+			return opts, "", err
+		}
+		issuer, err := oidc.Fetch(context.Background(), c)
+		if err != nil {
+			return opts, "", err
+		}
+		issuerURL = issuer.Discovery.Issuer
+	}
+	u, err := url.Parse(issuerURL)
+	if err != nil {
+		return opts, "", err
+	}
+	return publish.S3Location(opts, u)
+}
+
+// shortHash shortens a hash for display, like a git commit.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.AddCommand(historyListCmd)
+	historyCmd.AddCommand(historyRollbackCmd)
+	historyCmd.PersistentFlags().String(issuerURLFlag, "", "Public issuer URL the config is published at, defaults to the issuer of the cluster")
+	historyCmd.PersistentFlags().String(bucketFlag, "", "S3 bucket the config is published to instead of the one of the issuer URL, e.g. with --publish-mode cloudfront")
+	historyCmd.PersistentFlags().String(regionFlag, "", "AWS region of --bucket")
+	historyCmd.PersistentFlags().String(prefixFlag, "", "Path in --bucket the config is published at")
+	publish.AddS3Flags(historyCmd.PersistentFlags())
+	historyListCmd.Flags().StringP(outputFormat, "o", "", "output format: table, yaml or json")
+}
@@ -0,0 +1,83 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/apiserver"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/spf13/cobra"
+)
+
+const (
+	distroFlag         = "distro"
+	signingKeyFileFlag = "signing-key-file"
+	keyFileFlag        = "key-file"
+	hostKeyDirFlag     = "host-key-dir"
+)
+
+var printAPIServerConfigCmd = &cobra.Command{
+	Use:   "print-apiserver-config",
+	Short: "print kube-apiserver config for the issuer url",
+	Long: `print the kube-apiserver service account flags for the issuer url oidc config is published at,
+as a ClusterConfiguration patch for kubeadm, a config.yaml fragment for k3s and rke2 or a cluster config for kind`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		distro, err := cmd.Flags().GetString(distroFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		issuerURL, err := cmd.Flags().GetString(issuerURLFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		audiences, err := cmd.Flags().GetStringSlice(apiAudiencesFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		signingKey, err := cmd.Flags().GetString(signingKeyFileFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		keyFiles, err := cmd.Flags().GetStringSlice(keyFileFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		hostKeyDir, err := cmd.Flags().GetString(hostKeyDirFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if !strings.HasPrefix(issuerURL, "https://") {
+			log.Error(fmt.Errorf("issuer URL %s must be https", issuerURL))
+			return
+		}
+		out, err := apiserver.Render(distro, apiserver.Options{
+			Issuer:         issuerURL,
+			Audiences:      audiences,
+			SigningKeyFile: signingKey,
+			KeyFiles:       keyFiles,
+		}, hostKeyDir)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		fmt.Print(out)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(printAPIServerConfigCmd)
+	printAPIServerConfigCmd.Flags().String(distroFlag, apiserver.Kubeadm, "Kubernetes distribution: "+strings.Join(apiserver.Distros, ", "))
+	printAPIServerConfigCmd.Flags().String(issuerURLFlag, "", "Issuer URL oidc config is published at, e.g. by get --upload-to-s3 or bootstrap")
+	printAPIServerConfigCmd.Flags().StringSlice(apiAudiencesFlag, nil, "--api-audiences of the API server, defaults to the issuer URL")
+	printAPIServerConfigCmd.Flags().String(signingKeyFileFlag, "", "--service-account-signing-key-file, defaults to the key the distribution creates")
+	printAPIServerConfigCmd.Flags().StringSlice(keyFileFlag, nil, "--service-account-key-file, defaults to the key the distribution creates")
+	printAPIServerConfigCmd.Flags().String(hostKeyDirFlag, "", "For kind, mount sa.key and sa.pub from this directory, e.g. the --key-dir of bootstrap")
+	printAPIServerConfigCmd.MarkFlagRequired(issuerURLFlag)
+}
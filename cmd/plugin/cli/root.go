@@ -12,7 +12,6 @@ import (
 var (
 	KubernetesConfigFlags *genericclioptions.ConfigFlags
 	awsProfile            = "aws-profile"
-	kubeConfigPath        = "kubeconfig"
 )
 
 var rootCmd = &cobra.Command{
@@ -30,7 +29,8 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	rootCmd.PersistentFlags().String(kubeConfigPath, "", "Path to kubeconfig")
+	KubernetesConfigFlags = genericclioptions.NewConfigFlags(true)
+	KubernetesConfigFlags.AddFlags(rootCmd.PersistentFlags())
 	rootCmd.PersistentFlags().String(awsProfile, "default", "AWS profile name in .aws/config")
 }
 
@@ -0,0 +1,322 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/apiserver"
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/publish"
+	"github.com/shundezhang/oidc-config/pkg/rotation"
+	"github.com/spf13/cobra"
+)
+
+const (
+	stateFileFlag          = "state-file"
+	waitFlag               = "wait"
+	propagationTimeoutFlag = "propagation-timeout"
+
+	newSigningKeyFile = "sa-new.key"
+	newPublicKeyFile  = "sa-new.pub"
+)
+
+type rotateOptions struct {
+	issuerURL          string
+	stateFile          string
+	keyDir             string
+	keyBits            int
+	pkiDir             string
+	wait               time.Duration
+	propagationTimeout time.Duration
+	s3                 aws.S3Options
+}
+
+var rotateKeysCmd = &cobra.Command{
+	Use:   "rotate-keys",
+	Short: "rotate the service account signing key without breaking tokens",
+	Long: `rotate the service account signing key in phases: generate a new key, publish it next to the
+old one, wait until the issuer serves both, switch the API server to the new key and finally
+remove the old key once tokens signed by it have expired. Progress is kept in --state-file;
+run the command again to resume or continue the rotation`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		o := &rotateOptions{}
+		var err error
+		o.issuerURL, err = cmd.Flags().GetString(issuerURLFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.stateFile, err = cmd.Flags().GetString(stateFileFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.keyDir, err = cmd.Flags().GetString(keyDirFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.keyBits, err = cmd.Flags().GetInt(keyBitsFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.pkiDir, err = cmd.Flags().GetString(pkiDirFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.wait, err = cmd.Flags().GetDuration(waitFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.propagationTimeout, err = cmd.Flags().GetDuration(propagationTimeoutFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		profile, err := cmd.Flags().GetString(awsProfile)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		o.s3, err = publish.S3OptionsFromFlags(cmd.Flags(), profile)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if err := o.run(context.Background()); err != nil {
+			log.Error(err)
+			return
+		}
+	},
+}
+
+// run advances the rotation until it needs the operator or time to pass. The
+// state is saved after every phase.
+func (o *rotateOptions) run(ctx context.Context) error {
+	log := logger.NewLogger()
+	state, err := rotation.Load(o.stateFile)
+	if err != nil {
+		return err
+	}
+	for {
+		switch state.Phase {
+		case rotation.Started:
+			err = o.generate(ctx, state)
+		case rotation.Generated:
+			err = o.publish(state, state.JWKS())
+			state.PublishedAt = time.Now()
+		case rotation.Published:
+			err = o.waitForPropagation(ctx, state)
+			state.PropagatedAt = time.Now()
+		case rotation.Propagated:
+			var switched bool
+			switched, err = o.checkSwitched(ctx, state)
+			if err == nil && !switched {
+				o.printSwitchInstructions(state)
+				return nil
+			}
+			state.SwitchedAt = time.Now()
+		case rotation.Switched:
+			if left := time.Until(state.SwitchedAt.Add(o.wait)); left > 0 {
+				log.Info("Old keys stay published until %s, %s from now; run rotate-keys again then",
+					state.SwitchedAt.Add(o.wait).Format(time.RFC3339), left.Round(time.Minute))
+				return nil
+			}
+			err = o.publish(state, &oidc.JWKS{Keys: []oidc.JSONWebKey{*state.NewKey}})
+			state.FinishedAt = time.Now()
+		case rotation.Finished:
+			o.printFinishInstructions(state)
+			return nil
+		default:
+			return fmt.Errorf("unknown phase %q in %s", state.Phase, o.stateFile)
+		}
+		if err != nil {
+			return err
+		}
+		state.Phase = nextPhase[state.Phase]
+		if err := state.Save(o.stateFile); err != nil {
+			return err
+		}
+		log.Info("Rotation is %s, state saved to %s", state.Phase, o.stateFile)
+	}
+}
+
+var nextPhase = map[rotation.Phase]rotation.Phase{
+	rotation.Started:    rotation.Generated,
+	rotation.Generated:  rotation.Published,
+	rotation.Published:  rotation.Propagated,
+	rotation.Propagated: rotation.Switched,
+	rotation.Switched:   rotation.Finished,
+}
+
+// generate records the keys the cluster serves now and creates the new key.
+func (o *rotateOptions) generate(ctx context.Context, state *rotation.State) error {
+	c, err := k8s.GetKubernetesConfig(KubernetesConfigFlags)
+	if err != nil {
+		return err
+	}
+	issuer, err := oidc.Fetch(ctx, c)
+	if err != nil {
+		return err
+	}
+	state.IssuerURL = issuer.Discovery.Issuer
+	if o.issuerURL != "" {
+		if err := issuer.CheckTokenIssuer(o.issuerURL); err != nil {
+			return err
+		}
+		state.IssuerURL = o.issuerURL
+	}
+	state.OldKeys = issuer.JWKS
+	state.NewKeyFile = filepath.Join(o.keyDir, newSigningKeyFile)
+	state.NewPublicFile = filepath.Join(o.keyDir, newPublicKeyFile)
+	pub, generated, err := oidc.LoadOrGenerateSigningKey(state.NewKeyFile, state.NewPublicFile, o.keyBits)
+	if err != nil {
+		return err
+	}
+	state.NewKey, err = oidc.NewJSONWebKey(pub)
+	if err != nil {
+		return err
+	}
+	if state.OldKeys.Key(state.NewKey.KeyID) != nil {
+		if !generated {
+			// the key of a finished rotation, which the cluster signs with now
+			return fmt.Errorf("the cluster already serves key %s of %s from a previous rotation, rename %s and %s to %s and %s or move them out of %s before starting another rotation",
+				state.NewKey.KeyID, state.NewKeyFile, newSigningKeyFile, newPublicKeyFile, signingKeyFile, publicKeyFile, o.keyDir)
+		}
+		return fmt.Errorf("the cluster already serves key %s of %s", state.NewKey.KeyID, state.NewKeyFile)
+	}
+	return nil
+}
+
+// publish uploads config and jwks with the given keys to the bucket of the
+// issuer.
+func (o *rotateOptions) publish(state *rotation.State, jwks *oidc.JWKS) error {
+	log := logger.NewLogger()
+	issuer, err := oidc.NewIssuerFromJWKS(state.IssuerURL, jwks)
+	if err != nil {
+		return err
+	}
+	p := &publish.S3Publisher{Options: o.s3}
+	if _, err := p.Publish(context.Background(), issuer); err != nil {
+		return err
+	}
+	log.Info("Published keys %v", jwks.KeyIDs())
+	return nil
+}
+
+// waitForPropagation polls the public issuer until it serves the old and the
+// new keys.
+func (o *rotateOptions) waitForPropagation(ctx context.Context, state *rotation.State) error {
+	log := logger.NewLogger()
+	want := state.JWKS().KeyIDs()
+	deadline := time.Now().Add(o.propagationTimeout)
+	for {
+		missing, err := missingKeys(ctx, state.IssuerURL, want)
+		if err == nil && len(missing) == 0 {
+			log.Info("%s serves keys %v", state.IssuerURL, want)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("issuer does not serve the new keys after %s: %v", o.propagationTimeout, err)
+			}
+			return fmt.Errorf("issuer does not serve keys %v after %s, run rotate-keys again to keep waiting", missing, o.propagationTimeout)
+		}
+		if err != nil {
+			log.Info("Waiting for %s: %v", state.IssuerURL, err)
+		} else {
+			log.Info("Waiting for %s to serve keys %v", state.IssuerURL, missing)
+		}
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func missingKeys(ctx context.Context, issuerURL string, kids []string) ([]string, error) {
+	published, err := oidc.FetchPublic(ctx, issuerURL)
+	if err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, kid := range kids {
+		if published.JWKS.Key(kid) == nil {
+			missing = append(missing, kid)
+		}
+	}
+	return missing, nil
+}
+
+// checkSwitched checks if the API server has been restarted with the new key,
+// which it then serves next to the old ones.
+func (o *rotateOptions) checkSwitched(ctx context.Context, state *rotation.State) (bool, error) {
+	c, err := k8s.GetKubernetesConfig(KubernetesConfigFlags)
+	if err != nil {
+		return false, err
+	}
+	issuer, err := oidc.Fetch(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	return issuer.JWKS.Key(state.NewKey.KeyID) != nil, nil
+}
+
+func (o *rotateOptions) printSwitchInstructions(state *rotation.State) {
+	log := logger.NewLogger()
+	flags := &apiserver.Options{
+		Issuer:         state.IssuerURL,
+		SigningKeyFile: o.pkiPath(newSigningKeyFile),
+		KeyFiles:       []string{o.pkiPath(newPublicKeyFile), o.pkiPath(publicKeyFile)},
+	}
+	log.Instructions(`Copy %s and %s to %s on every control plane node and restart kube-apiserver with:
+
+  %s
+
+Keep your current --api-audiences. The old public key stays in --service-account-key-file so
+tokens it signed still validate. Run rotate-keys again once all API servers are restarted.`,
+		state.NewKeyFile, state.NewPublicFile, o.pkiDir, strings.Join(flags.Args(), " \\\n  "))
+}
+
+func (o *rotateOptions) printFinishInstructions(state *rotation.State) {
+	log := logger.NewLogger()
+	flags := &apiserver.Options{
+		Issuer:         state.IssuerURL,
+		SigningKeyFile: o.pkiPath(signingKeyFile),
+		KeyFiles:       []string{o.pkiPath(publicKeyFile)},
+	}
+	log.Instructions(`Rotation finished at %s, only key %s is published. On every control plane node rename
+%s and %s to %s and %s, replacing the old key, and restart kube-apiserver with:
+
+  %s
+
+Rename %s and %s the same way and delete %s before starting another rotation,
+which generates a new %s.`,
+		state.FinishedAt.Format(time.RFC3339), state.NewKey.KeyID,
+		o.pkiPath(newSigningKeyFile), o.pkiPath(newPublicKeyFile), signingKeyFile, publicKeyFile,
+		strings.Join(flags.Args(), " \\\n  "),
+		state.NewKeyFile, state.NewPublicFile, o.stateFile, newSigningKeyFile)
+}
+
+func (o *rotateOptions) pkiPath(file string) string {
+	return filepath.ToSlash(filepath.Join(o.pkiDir, file))
+}
+
+func init() {
+	rootCmd.AddCommand(rotateKeysCmd)
+	rotateKeysCmd.Flags().String(issuerURLFlag, "", "Public issuer URL the config is published at, defaults to the issuer of the cluster")
+	rotateKeysCmd.Flags().String(stateFileFlag, "rotation.json", "File the progress of the rotation is kept in")
+	rotateKeysCmd.Flags().String(keyDirFlag, ".", "Directory to write "+newSigningKeyFile+" and "+newPublicKeyFile+" to")
+	rotateKeysCmd.Flags().Int(keyBitsFlag, oidc.DefaultKeyBits, "Size of the generated RSA signing key")
+	rotateKeysCmd.Flags().String(pkiDirFlag, "/etc/kubernetes/pki", "Directory the keys are in on the control plane nodes, the old public key is expected as "+publicKeyFile)
+	rotateKeysCmd.Flags().Duration(waitFlag, 48*time.Hour, "How long the old key stays published after the API server has switched to the new key; at least the longest token lifetime")
+	publish.AddS3Flags(rotateKeysCmd.Flags())
+	rotateKeysCmd.Flags().Duration(propagationTimeoutFlag, 10*time.Minute, "How long to wait for the issuer to serve the new key")
+}
@@ -0,0 +1,28 @@
+package cli
+
+import (
+	"testing"
+
+	"github.com/shundezhang/oidc-config/pkg/rotation"
+)
+
+func TestNextPhase(t *testing.T) {
+	want := []rotation.Phase{rotation.Started, rotation.Generated, rotation.Published, rotation.Propagated, rotation.Switched, rotation.Finished}
+	phase := rotation.Started
+	for i, w := range want {
+		if phase != w {
+			t.Fatalf("phase %d = %q, want %q", i, phase, w)
+		}
+		next, ok := nextPhase[phase]
+		if phase == rotation.Finished {
+			if ok {
+				t.Errorf("phase after %q = %q, want none", phase, next)
+			}
+			break
+		}
+		if !ok {
+			t.Fatalf("no phase after %q", phase)
+		}
+		phase = next
+	}
+}
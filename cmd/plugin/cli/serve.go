@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/server"
+	"github.com/spf13/cobra"
+)
+
+const (
+	listenFlag          = "listen"
+	certFlag            = "cert"
+	keyFlag             = "key"
+	refreshIntervalFlag = "refresh-interval"
+	refreshTimeoutFlag  = "refresh-timeout"
+	maxAgeFlag          = "max-age"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "serve oidc config and jwks of one or more clusters over https",
+	Long: `serve /.well-known/openid-configuration and /openid/v1/jwks of one or more clusters,
+each at the path of its issuer URL, refreshed from the API servers on an interval`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		listen, err := cmd.Flags().GetString(listenFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		cert, err := cmd.Flags().GetString(certFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		key, err := cmd.Flags().GetString(keyFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		interval, err := cmd.Flags().GetDuration(refreshIntervalFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		maxAge, err := cmd.Flags().GetDuration(maxAgeFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		timeout, err := cmd.Flags().GetDuration(refreshTimeoutFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		issuerURL, err := cmd.Flags().GetString(issuerURLFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if (cert == "") != (key == "") {
+			log.Error(fmt.Errorf("--%s and --%s must be given together", certFlag, keyFlag))
+			return
+		}
+		contexts, err := batchContexts(cmd)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		s := &server.Server{MaxAge: maxAge, RefreshTimeout: timeout}
+		if len(contexts) == 0 {
+			s.Sources = []server.Source{{Name: "current context", Getter: KubernetesConfigFlags, IssuerURL: issuerURL}}
+		}
+		slugs, err := contextSlugs(contexts)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		for _, c := range contexts {
+			src := server.Source{Name: c, Getter: configFlagsForContext(KubernetesConfigFlags, c)}
+			if issuerURL != "" {
+				// one issuer per context under the base URL
+				src.IssuerURL = strings.TrimSuffix(issuerURL, "/") + "/" + slugs[c]
+			}
+			s.Sources = append(s.Sources, src)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		go s.Run(ctx, interval)
+		srv := &http.Server{Addr: listen, Handler: s}
+		go func() {
+			<-ctx.Done()
+			shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdown)
+		}()
+		if cert != "" {
+			log.Info("Serving https on %s", listen)
+			err = srv.ListenAndServeTLS(cert, key)
+		} else {
+			log.Info("Serving http on %s, without --%s and --%s", listen, certFlag, keyFlag)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error(err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().String(listenFlag, ":8443", "Address to listen on")
+	serveCmd.Flags().String(certFlag, "", "PEM certificate file to serve https with, http is served if not set")
+	serveCmd.Flags().String(keyFlag, "", "PEM private key file of --"+certFlag)
+	serveCmd.Flags().Duration(refreshIntervalFlag, time.Minute, "How often config and jwks are fetched from the API servers")
+	serveCmd.Flags().Duration(refreshTimeoutFlag, server.DefaultRefreshTimeout, "How long fetching config and jwks from one API server may take before the documents fetched before are served on")
+	serveCmd.Flags().Duration(maxAgeFlag, 5*time.Minute, "How long verifiers may cache config and jwks, sent as Cache-Control max-age")
+	serveCmd.Flags().String(issuerURLFlag, "", "Public issuer URL the documents are rewritten to and served under; with --contexts or --all-contexts, the base URL each context is served under as <issuer-url>/<context>, with the cluster name for EKS context ARNs and other characters than letters, digits, '.', '_' and '-' replaced by '-'")
+	serveCmd.Flags().Bool(allContextsFlag, false, "Serve every context in the kubeconfig")
+	serveCmd.Flags().StringSlice(contextsFlag, nil, "Serve these contexts, e.g. a,b,c")
+}
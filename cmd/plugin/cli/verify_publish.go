@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const ignoreRemovedFlag = "ignore-removed"
+
+var verifyPublishCmd = &cobra.Command{
+	Use:   "verify-publish",
+	Short: "compare the jwks of the cluster with the published copy",
+	Long: `get the jwks from the API server and from the public issuer url, compare them by kid
+and exit non-zero if keys were added, removed or changed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		log := logger.NewLogger()
+		output, err := cmd.Flags().GetString(outputFormat)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		issuerURL, err := cmd.Flags().GetString(issuerURLFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		ignoreRemoved, err := cmd.Flags().GetBool(ignoreRemovedFlag)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		c, err := k8s.GetKubernetesConfig(KubernetesConfigFlags)
+		if err != nil {
+			log.Error(err)
+			os.Exit(2)
+		}
+		cluster, err := oidc.Fetch(context.Background(), c)
+		if err != nil {
+			log.Error(err)
+			os.Exit(2)
+		}
+		if issuerURL == "" {
+			issuerURL = cluster.Discovery.Issuer
+		}
+		published, err := oidc.FetchPublic(context.Background(), issuerURL)
+		if err != nil {
+			log.Error(err)
+			os.Exit(2)
+		}
+		diff := oidc.DiffJWKS(cluster.JWKS, published.JWKS)
+		switch output {
+		case "json":
+			b, err := json.MarshalIndent(diff, "", "  ")
+			if err != nil {
+				log.Error(err)
+				os.Exit(2)
+			}
+			fmt.Println(string(b))
+		case "yaml":
+			b, err := yaml.Marshal(diff)
+			if err != nil {
+				log.Error(err)
+				os.Exit(2)
+			}
+			fmt.Print(string(b))
+		default:
+			for _, kid := range diff.Added {
+				fmt.Printf("+ %s (in cluster, not published)\n", kid)
+			}
+			for _, kid := range diff.Removed {
+				fmt.Printf("- %s (published, not in cluster)\n", kid)
+			}
+			for _, kid := range diff.Changed {
+				fmt.Printf("~ %s (published with different key material)\n", kid)
+			}
+			if diff.Empty() {
+				fmt.Printf("%s%s matches the cluster jwks\n", issuerURL, oidc.JWKSPath)
+			}
+		}
+		if ignoreRemoved {
+			diff.Removed = nil
+		}
+		if !diff.Empty() {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyPublishCmd)
+	verifyPublishCmd.Flags().StringP(outputFormat, "o", "", "output format: default, yaml or json")
+	verifyPublishCmd.Flags().Bool(ignoreRemovedFlag, false, "Don't fail on published keys the cluster no longer has, e.g. retired keys kept by get --merge-keys")
+	verifyPublishCmd.Flags().String(issuerURLFlag, "", "Public issuer URL to compare with, defaults to the issuer of the cluster")
+}
@@ -0,0 +1,164 @@
+package apiserver
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	Kubeadm = "kubeadm"
+	K3s     = "k3s"
+	RKE2    = "rke2"
+	Kind    = "kind"
+)
+
+var Distros = []string{Kubeadm, K3s, RKE2, Kind}
+
+// defaultKeyFiles are the signing and public key files each distribution
+// creates on the control plane nodes.
+var defaultKeyFiles = map[string][2]string{
+	Kubeadm: {"/etc/kubernetes/pki/sa.key", "/etc/kubernetes/pki/sa.pub"},
+	Kind:    {"/etc/kubernetes/pki/sa.key", "/etc/kubernetes/pki/sa.pub"},
+	K3s:     {"/var/lib/rancher/k3s/server/tls/service.key", "/var/lib/rancher/k3s/server/tls/service.key"},
+	RKE2:    {"/var/lib/rancher/rke2/server/tls/service.key", "/var/lib/rancher/rke2/server/tls/service.key"},
+}
+
+type kubeadmConfig struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	APIServer  kubeadmAPIServer `yaml:"apiServer"`
+}
+
+type kubeadmAPIServer struct {
+	ExtraArgs map[string]string `yaml:"extraArgs"`
+}
+
+type rancherConfig struct {
+	KubeAPIServerArgs []string `yaml:"kube-apiserver-arg"`
+}
+
+type kindCluster struct {
+	Kind                 string     `yaml:"kind"`
+	APIVersion           string     `yaml:"apiVersion"`
+	Nodes                []kindNode `yaml:"nodes"`
+	KubeadmConfigPatches []string   `yaml:"kubeadmConfigPatches"`
+}
+
+type kindNode struct {
+	Role        string      `yaml:"role"`
+	ExtraMounts []kindMount `yaml:"extraMounts,omitempty"`
+}
+
+type kindMount struct {
+	HostPath      string `yaml:"hostPath"`
+	ContainerPath string `yaml:"containerPath"`
+	ReadOnly      bool   `yaml:"readOnly"`
+}
+
+// WithDefaults returns a copy of the options with the key files the
+// distribution uses filled in where they are not set.
+func (o Options) WithDefaults(distro string) Options {
+	keys, ok := defaultKeyFiles[distro]
+	if !ok {
+		return o
+	}
+	if o.SigningKeyFile == "" {
+		o.SigningKeyFile = keys[0]
+	}
+	if len(o.KeyFiles) == 0 {
+		o.KeyFiles = []string{keys[1]}
+	}
+	return o
+}
+
+// Render returns the API server configuration for a distribution: a
+// ClusterConfiguration patch for kubeadm, a config.yaml fragment for k3s and
+// RKE2 and a cluster config for kind. For kind, hostKeyDir is mounted into the
+// control plane node if set, so a key created by bootstrap is used.
+func Render(distro string, o Options, hostKeyDir string) (string, error) {
+	o = o.WithDefaults(distro)
+	var out interface{}
+	switch distro {
+	case Kubeadm:
+		cfg, err := o.kubeadmConfig()
+		if err != nil {
+			return "", err
+		}
+		out = cfg
+	case K3s, RKE2:
+		cfg := &rancherConfig{}
+		for _, f := range o.flags() {
+			cfg.KubeAPIServerArgs = append(cfg.KubeAPIServerArgs, fmt.Sprintf("%s=%s", f.name, f.value))
+		}
+		out = cfg
+	case Kind:
+		cfg, err := o.kubeadmConfig()
+		if err != nil {
+			return "", err
+		}
+		// kind fills in apiVersion for kubeadmConfigPatches
+		patch, err := marshal(struct {
+			Kind      string           `yaml:"kind"`
+			APIServer kubeadmAPIServer `yaml:"apiServer"`
+		}{cfg.Kind, cfg.APIServer})
+		if err != nil {
+			return "", err
+		}
+		node := kindNode{Role: "control-plane"}
+		if hostKeyDir != "" {
+			for _, f := range []string{o.SigningKeyFile, o.KeyFiles[0]} {
+				node.ExtraMounts = append(node.ExtraMounts, kindMount{
+					HostPath:      filepath.Join(hostKeyDir, path.Base(f)),
+					ContainerPath: f,
+					ReadOnly:      true,
+				})
+			}
+		}
+		out = &kindCluster{
+			Kind:                 "Cluster",
+			APIVersion:           "kind.x-k8s.io/v1alpha4",
+			Nodes:                []kindNode{node},
+			KubeadmConfigPatches: []string{string(patch)},
+		}
+	default:
+		return "", fmt.Errorf("distro %s not supported, use one of %v", distro, Distros)
+	}
+	b, err := marshal(out)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (o *Options) kubeadmConfig() (*kubeadmConfig, error) {
+	if len(o.KeyFiles) > 1 {
+		// extraArgs is a map in v1beta3, so a flag can only be given once
+		return nil, fmt.Errorf("kubeadm supports a single --service-account-key-file, got %v", o.KeyFiles)
+	}
+	args := map[string]string{}
+	for _, f := range o.flags() {
+		args[f.name] = f.value
+	}
+	return &kubeadmConfig{
+		APIVersion: "kubeadm.k8s.io/v1beta3",
+		Kind:       "ClusterConfiguration",
+		APIServer:  kubeadmAPIServer{ExtraArgs: args},
+	}, nil
+}
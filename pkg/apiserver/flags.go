@@ -0,0 +1,68 @@
+package apiserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+// Options are the kube-apiserver settings for service account token issuance
+// that have to match the published OIDC config.
+type Options struct {
+	// Issuer is --service-account-issuer, the URL the config is published at.
+	Issuer string
+	// Audiences is --api-audiences, defaults to the issuer like kube-apiserver.
+	Audiences []string
+	// SigningKeyFile is --service-account-signing-key-file.
+	SigningKeyFile string
+	// KeyFiles are --service-account-key-file, the public keys tokens are
+	// verified with. Defaults to SigningKeyFile.
+	KeyFiles []string
+}
+
+// flag is a single kube-apiserver flag without the leading dashes.
+type flag struct {
+	name  string
+	value string
+}
+
+func (o *Options) JWKSURI() string {
+	return strings.TrimSuffix(o.Issuer, "/") + oidc.JWKSPath
+}
+
+func (o *Options) audiences() []string {
+	if len(o.Audiences) > 0 {
+		return o.Audiences
+	}
+	return []string{o.Issuer}
+}
+
+func (o *Options) keyFiles() []string {
+	if len(o.KeyFiles) > 0 {
+		return o.KeyFiles
+	}
+	return []string{o.SigningKeyFile}
+}
+
+func (o *Options) flags() []flag {
+	flags := []flag{
+		{"service-account-issuer", o.Issuer},
+		{"service-account-jwks-uri", o.JWKSURI()},
+		{"api-audiences", strings.Join(o.audiences(), ",")},
+		{"service-account-signing-key-file", o.SigningKeyFile},
+	}
+	for _, f := range o.keyFiles() {
+		flags = append(flags, flag{"service-account-key-file", f})
+	}
+	return flags
+}
+
+// Args returns the kube-apiserver flags.
+func (o *Options) Args() []string {
+	args := []string{}
+	for _, f := range o.flags() {
+		args = append(args, fmt.Sprintf("--%s=%s", f.name, f.value))
+	}
+	return args
+}
@@ -0,0 +1,218 @@
+package aws
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/util"
+)
+
+// cachingOptimizedPolicyID is the managed CachingOptimized cache policy. It
+// honours the Cache-Control of the objects, and caches objects without one
+// for a day.
+const cachingOptimizedPolicyID = "658327ea-f89d-4fab-a63d-7e88639e58f6"
+
+// CloudFrontOptions selects the distribution serving a private bucket.
+type CloudFrontOptions struct {
+	Profile string
+	Bucket  string
+	Region  string
+	// Domain is an optional custom domain of the distribution, which needs
+	// CertificateARN, an ACM certificate for it in us-east-1.
+	Domain         string
+	CertificateARN string
+}
+
+// Distribution is a CloudFront distribution in front of a bucket.
+type Distribution struct {
+	ID         string
+	ARN        string
+	DomainName string
+	Aliases    []string
+}
+
+func newCloudFrontClient(profile string) *cloudfront.CloudFront {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	// CloudFront is global, its API is in us-east-1
+	return cloudfront.New(sess, aws.NewConfig().WithRegion("us-east-1"))
+}
+
+func (o CloudFrontOptions) comment() string {
+	return "oidc-config " + o.Bucket
+}
+
+func (o CloudFrontOptions) originDomain() string {
+	return fmt.Sprintf("%s.s3.%s.amazonaws.com", o.Bucket, o.Region)
+}
+
+// EnsureDistribution returns the distribution serving the bucket, creating it
+// with an origin access control if there is none.
+func EnsureDistribution(opts CloudFrontOptions) (*Distribution, error) {
+	log := logger.NewLogger()
+	if opts.Domain != "" && opts.CertificateARN == "" {
+		return nil, fmt.Errorf("custom domain %s requires an ACM certificate in us-east-1", opts.Domain)
+	}
+	svc := newCloudFrontClient(opts.Profile)
+	dist, err := findDistribution(svc, opts)
+	if err != nil {
+		return nil, err
+	}
+	if dist != nil {
+		log.Info("distribution %s for bucket %s exists.", dist.ID, opts.Bucket)
+		if opts.Domain != "" && !util.Contains(dist.Aliases, strings.ToLower(opts.Domain)) {
+			return nil, fmt.Errorf("distribution %s does not have domain %s, add it as alternate domain name", dist.ID, opts.Domain)
+		}
+		return dist, nil
+	}
+	oacID, err := ensureOriginAccessControl(svc, opts)
+	if err != nil {
+		return nil, err
+	}
+	originID := "s3-" + opts.Bucket
+	config := &cloudfront.DistributionConfig{
+		CallerReference: aws.String(fmt.Sprintf("oidc-config-%s-%d", opts.Bucket, time.Now().Unix())),
+		Comment:         aws.String(opts.comment()),
+		Enabled:         aws.Bool(true),
+		Origins: &cloudfront.Origins{
+			Quantity: aws.Int64(1),
+			Items: []*cloudfront.Origin{{
+				Id:                    aws.String(originID),
+				DomainName:            aws.String(opts.originDomain()),
+				OriginAccessControlId: aws.String(oacID),
+				S3OriginConfig:        &cloudfront.S3OriginConfig{OriginAccessIdentity: aws.String("")},
+			}},
+		},
+		DefaultCacheBehavior: &cloudfront.DefaultCacheBehavior{
+			TargetOriginId:       aws.String(originID),
+			ViewerProtocolPolicy: aws.String(cloudfront.ViewerProtocolPolicyHttpsOnly),
+			CachePolicyId:        aws.String(cachingOptimizedPolicyID),
+		},
+		ViewerCertificate: &cloudfront.ViewerCertificate{CloudFrontDefaultCertificate: aws.Bool(true)},
+	}
+	if opts.Domain != "" {
+		config.Aliases = &cloudfront.Aliases{Quantity: aws.Int64(1), Items: []*string{aws.String(opts.Domain)}}
+		config.ViewerCertificate = &cloudfront.ViewerCertificate{
+			ACMCertificateArn:      aws.String(opts.CertificateARN),
+			SSLSupportMethod:       aws.String(cloudfront.SSLSupportMethodSniOnly),
+			MinimumProtocolVersion: aws.String(cloudfront.MinimumProtocolVersionTlsv122021),
+		}
+	}
+	log.Info("distribution for bucket %s not found, creating it...", opts.Bucket)
+	out, err := svc.CreateDistribution(&cloudfront.CreateDistributionInput{DistributionConfig: config})
+	if err != nil {
+		return nil, fmt.Errorf("can't create distribution for bucket %s: %v", opts.Bucket, err)
+	}
+	dist = &Distribution{
+		ID:         aws.StringValue(out.Distribution.Id),
+		ARN:        aws.StringValue(out.Distribution.ARN),
+		DomainName: aws.StringValue(out.Distribution.DomainName),
+	}
+	if opts.Domain != "" {
+		dist.Aliases = []string{opts.Domain}
+	}
+	log.Info("Created distribution %s at %s", dist.ID, dist.DomainName)
+	return dist, nil
+}
+
+// findDistribution finds a distribution created for the bucket by its comment.
+func findDistribution(svc *cloudfront.CloudFront, opts CloudFrontOptions) (*Distribution, error) {
+	var found *Distribution
+	err := svc.ListDistributionsPages(&cloudfront.ListDistributionsInput{}, func(page *cloudfront.ListDistributionsOutput, last bool) bool {
+		for _, d := range page.DistributionList.Items {
+			if aws.StringValue(d.Comment) != opts.comment() {
+				continue
+			}
+			found = &Distribution{
+				ID:         aws.StringValue(d.Id),
+				ARN:        aws.StringValue(d.ARN),
+				DomainName: aws.StringValue(d.DomainName),
+			}
+			if d.Aliases != nil {
+				// domain names are not case sensitive
+				for _, alias := range d.Aliases.Items {
+					found.Aliases = append(found.Aliases, strings.ToLower(aws.StringValue(alias)))
+				}
+			}
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list distributions: %v", err)
+	}
+	return found, nil
+}
+
+// ensureOriginAccessControl returns the id of the origin access control for
+// the bucket, creating it if missing.
+func ensureOriginAccessControl(svc *cloudfront.CloudFront, opts CloudFrontOptions) (string, error) {
+	name := "oidc-config-" + opts.Bucket
+	input := &cloudfront.ListOriginAccessControlsInput{}
+	for {
+		out, err := svc.ListOriginAccessControls(input)
+		if err != nil {
+			return "", fmt.Errorf("can't list origin access controls: %v", err)
+		}
+		for _, oac := range out.OriginAccessControlList.Items {
+			if aws.StringValue(oac.Name) == name {
+				return aws.StringValue(oac.Id), nil
+			}
+		}
+		if !aws.BoolValue(out.OriginAccessControlList.IsTruncated) {
+			break
+		}
+		input.Marker = out.OriginAccessControlList.NextMarker
+	}
+	out, err := svc.CreateOriginAccessControl(&cloudfront.CreateOriginAccessControlInput{
+		OriginAccessControlConfig: &cloudfront.OriginAccessControlConfig{
+			Name:                          aws.String(name),
+			Description:                   aws.String(opts.comment()),
+			OriginAccessControlOriginType: aws.String(cloudfront.OriginAccessControlOriginTypesS3),
+			SigningBehavior:               aws.String(cloudfront.OriginAccessControlSigningBehaviorsAlways),
+			SigningProtocol:               aws.String(cloudfront.OriginAccessControlSigningProtocolsSigv4),
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't create origin access control %s: %v", name, err)
+	}
+	return aws.StringValue(out.OriginAccessControl.Id), nil
+}
+
+// WaitForDistribution waits until a distribution is deployed and serves its
+// domain.
+func WaitForDistribution(profile, id string) error {
+	log := logger.NewLogger()
+	log.Info("Waiting for distribution %s to be deployed, this takes a few minutes...", id)
+	if err := newCloudFrontClient(profile).WaitUntilDistributionDeployed(&cloudfront.GetDistributionInput{Id: aws.String(id)}); err != nil {
+		return fmt.Errorf("distribution %s: %v", id, err)
+	}
+	return nil
+}
+
+// InvalidateDistribution removes paths from the caches of the distribution
+// with the given ARN, so that changed objects are served right away instead
+// of when the cached copies expire.
+func InvalidateDistribution(profile, distributionARN string, paths []string) error {
+	log := logger.NewLogger()
+	id := distributionARN[strings.LastIndex(distributionARN, "/")+1:]
+	out, err := newCloudFrontClient(profile).CreateInvalidation(&cloudfront.CreateInvalidationInput{
+		DistributionId: aws.String(id),
+		InvalidationBatch: &cloudfront.InvalidationBatch{
+			CallerReference: aws.String(fmt.Sprintf("oidc-config-%d", time.Now().UnixNano())),
+			Paths:           &cloudfront.Paths{Quantity: aws.Int64(int64(len(paths))), Items: aws.StringSlice(paths)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("can't invalidate %v in distribution %s: %v", paths, id, err)
+	}
+	log.Info("Invalidating %v in distribution %s, invalidation %s", paths, id, aws.StringValue(out.Invalidation.Id))
+	return nil
+}
@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -17,6 +19,8 @@ import (
 	"github.com/shundezhang/oidc-config/pkg/logger"
 )
 
+const stsAudience = "sts.amazonaws.com"
+
 var trustedPolicyTemplate = `{
 	"Version": "2012-10-17",
 	"Statement": [
@@ -42,12 +46,16 @@ func CreateOIDCProvider(profile, providerUrl string) error {
 		SharedConfigState: session.SharedConfigEnable,
 	}))
 	svc := iam.New(sess)
+	thumbprint, err := GetThumbprint(providerUrl)
+	if err != nil {
+		return err
+	}
 	input := &iam.CreateOpenIDConnectProviderInput{
 		ClientIDList: []*string{
-			aws.String("sts.amazonaws.com"),
+			aws.String(stsAudience),
 		},
 		ThumbprintList: []*string{
-			aws.String(getThumbPrint(providerUrl)),
+			aws.String(thumbprint),
 		},
 		Url: aws.String(providerUrl),
 	}
@@ -57,17 +65,17 @@ func CreateOIDCProvider(profile, providerUrl string) error {
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case iam.ErrCodeInvalidInputException:
-				fmt.Println(iam.ErrCodeInvalidInputException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeInvalidInputException, aerr.Error())
 			case iam.ErrCodeEntityAlreadyExistsException:
-				fmt.Println(iam.ErrCodeEntityAlreadyExistsException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeEntityAlreadyExistsException, aerr.Error())
 			case iam.ErrCodeLimitExceededException:
-				fmt.Println(iam.ErrCodeLimitExceededException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeLimitExceededException, aerr.Error())
 			case iam.ErrCodeConcurrentModificationException:
-				fmt.Println(iam.ErrCodeConcurrentModificationException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeConcurrentModificationException, aerr.Error())
 			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeServiceFailureException, aerr.Error())
 			default:
-				fmt.Println(aerr.Error())
+				log.Info("%s", aerr.Error())
 			}
 		} else {
 			// Print the error, cast err to awserr.Error to get the Code and
@@ -77,15 +85,31 @@ func CreateOIDCProvider(profile, providerUrl string) error {
 		return err
 	}
 
-	fmt.Println(result)
+	log.Info("%v", result)
 	return nil
 }
 
-func getThumbPrint(httpsUrl string) string {
+// GetThumbprint returns the SHA-1 fingerprint of the top CA of the chain
+// served by the host of httpsUrl, in the format IAM expects for OIDC
+// providers.
+func GetThumbprint(httpsUrl string) (string, error) {
+	log := logger.NewLogger()
+	log.Info("getting thumbprint from %s", httpsUrl)
+	chain, err := GetCertificateChain(httpsUrl)
+	if err != nil {
+		return "", err
+	}
+	thumbprint := Thumbprint(TopCA(chain))
+	log.Info("Fingerprint for %s: %s", httpsUrl, thumbprint)
+	return thumbprint, nil
+}
+
+// GetCertificateChain returns the verified certificate chain served by the
+// host of httpsUrl, from the leaf to the top CA.
+func GetCertificateChain(httpsUrl string) ([]*x509.Certificate, error) {
 	u, err := url.Parse(httpsUrl)
 	if err != nil {
-		fmt.Println(err)
-		return ""
+		return nil, err
 	}
 	add := u.Hostname()
 	if u.Port() != "" {
@@ -93,32 +117,39 @@ func getThumbPrint(httpsUrl string) string {
 	} else if u.Port() == "" && u.Scheme == "https" {
 		add = add + ":443"
 	}
-	fmt.Printf("getting thumbprint from %s\n", add)
 	conn, err := tls.Dial("tcp", add, &tls.Config{})
 	if err != nil {
-		panic("failed to connect: " + err.Error())
+		return nil, fmt.Errorf("failed to connect: %v", err)
 	}
+	defer conn.Close()
 
-	// Get the ConnectionState struct as that's the one which gives us x509.Certificate struct
-	cert := conn.ConnectionState().PeerCertificates[0]
-	// fmt.Printf("%s\n", cert.Issuer)
+	chains := conn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return nil, fmt.Errorf("%s served no verified certificate chain", add)
+	}
+	return chains[0], nil
+}
+
+// TopCA returns the last certificate of a chain, the one IAM takes the
+// thumbprint of for an OIDC provider.
+func TopCA(chain []*x509.Certificate) *x509.Certificate {
+	return chain[len(chain)-1]
+}
+
+// Thumbprint returns the SHA-1 fingerprint of a certificate in the format IAM
+// expects for OIDC providers.
+func Thumbprint(cert *x509.Certificate) string {
 	fingerprint := sha1.Sum(cert.Raw)
 
 	var buf bytes.Buffer
 	for _, f := range fingerprint {
-		// if i > 0 {
-		// 	fmt.Fprintf(&buf, ":")
-		// }
 		fmt.Fprintf(&buf, "%02X", f)
 	}
-	fmt.Printf("%x", fingerprint)
-	fmt.Printf("Fingerprint for %s: %s", httpsUrl, buf.String())
-
-	defer conn.Close()
 	return buf.String()
 }
 
 func CreateRole(profile, roleName, policyArn, oidcProviderArn, saNamespace, sa string) (string, error) {
+	log := logger.NewLogger()
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		Profile:           profile,
 		SharedConfigState: session.SharedConfigEnable,
@@ -134,28 +165,28 @@ func CreateRole(profile, roleName, policyArn, oidcProviderArn, saNamespace, sa s
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case iam.ErrCodeLimitExceededException:
-				fmt.Println(iam.ErrCodeLimitExceededException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeLimitExceededException, aerr.Error())
 			case iam.ErrCodeInvalidInputException:
-				fmt.Println(iam.ErrCodeInvalidInputException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeInvalidInputException, aerr.Error())
 			case iam.ErrCodeEntityAlreadyExistsException:
-				fmt.Println(iam.ErrCodeEntityAlreadyExistsException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeEntityAlreadyExistsException, aerr.Error())
 			case iam.ErrCodeMalformedPolicyDocumentException:
-				fmt.Println(iam.ErrCodeMalformedPolicyDocumentException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeMalformedPolicyDocumentException, aerr.Error())
 			case iam.ErrCodeConcurrentModificationException:
-				fmt.Println(iam.ErrCodeConcurrentModificationException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeConcurrentModificationException, aerr.Error())
 			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeServiceFailureException, aerr.Error())
 			default:
-				fmt.Println(aerr.Error())
+				log.Info("%s", aerr.Error())
 			}
 		} else {
 			// Print the error, cast err to awserr.Error to get the Code and
 			// Message from an error.
-			fmt.Println(err.Error())
+			log.Error(err)
 		}
 		return "", err
 	}
-	fmt.Println(result)
+	log.Info("%v", result)
 
 	inputP := &iam.AttachRolePolicyInput{
 		PolicyArn: aws.String(policyArn),
@@ -167,29 +198,29 @@ func CreateRole(profile, roleName, policyArn, oidcProviderArn, saNamespace, sa s
 		if aerr, ok := errP.(awserr.Error); ok {
 			switch aerr.Code() {
 			case iam.ErrCodeNoSuchEntityException:
-				fmt.Println(iam.ErrCodeNoSuchEntityException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeNoSuchEntityException, aerr.Error())
 			case iam.ErrCodeLimitExceededException:
-				fmt.Println(iam.ErrCodeLimitExceededException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeLimitExceededException, aerr.Error())
 			case iam.ErrCodeInvalidInputException:
-				fmt.Println(iam.ErrCodeInvalidInputException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeInvalidInputException, aerr.Error())
 			case iam.ErrCodeUnmodifiableEntityException:
-				fmt.Println(iam.ErrCodeUnmodifiableEntityException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeUnmodifiableEntityException, aerr.Error())
 			case iam.ErrCodePolicyNotAttachableException:
-				fmt.Println(iam.ErrCodePolicyNotAttachableException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodePolicyNotAttachableException, aerr.Error())
 			case iam.ErrCodeServiceFailureException:
-				fmt.Println(iam.ErrCodeServiceFailureException, aerr.Error())
+				log.Info("%s %s", iam.ErrCodeServiceFailureException, aerr.Error())
 			default:
-				fmt.Println(aerr.Error())
+				log.Info("%s", aerr.Error())
 			}
 		} else {
 			// Print the error, cast err to awserr.Error to get the Code and
 			// Message from an error.
-			fmt.Println(err.Error())
+			log.Error(errP)
 		}
 		return "", errP
 	}
 
-	fmt.Println(resultP)
+	log.Info("%v", resultP)
 
 	return *result.Role.Arn, nil
 }
@@ -229,3 +260,83 @@ func GetOIDCProviderARN(profile, issuer string) (string, error) {
 	}
 	return "", errors.New("OIDC Provider " + issuer + " not found.")
 }
+
+// OIDCProvider is an OIDC provider in IAM.
+type OIDCProvider struct {
+	ARN         string
+	URL         string
+	ClientIDs   []string
+	Thumbprints []string
+	CreateDate  time.Time
+}
+
+func GetOIDCProvider(profile, arn string) (*OIDCProvider, error) {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	svc := iam.New(sess)
+	result, err := svc.GetOpenIDConnectProvider(&iam.GetOpenIDConnectProviderInput{
+		OpenIDConnectProviderArn: aws.String(arn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCProvider{
+		ARN:         arn,
+		URL:         aws.StringValue(result.Url),
+		ClientIDs:   aws.StringValueSlice(result.ClientIDList),
+		Thumbprints: aws.StringValueSlice(result.ThumbprintList),
+		CreateDate:  aws.TimeValue(result.CreateDate),
+	}, nil
+}
+
+// maxThumbprints is how many thumbprints IAM keeps for an OIDC provider.
+const maxThumbprints = 5
+
+// AddOIDCProviderThumbprint puts thumbprint first in the thumbprints of an
+// OIDC provider. The previous ones are kept, up to the IAM limit, so
+// verification does not break while a certificate rolls over.
+func AddOIDCProviderThumbprint(profile, arn, thumbprint string) error {
+	log := logger.NewLogger()
+	provider, err := GetOIDCProvider(profile, arn)
+	if err != nil {
+		return err
+	}
+	thumbprints := []string{thumbprint}
+	for _, t := range provider.Thumbprints {
+		if !strings.EqualFold(t, thumbprint) && len(thumbprints) < maxThumbprints {
+			thumbprints = append(thumbprints, t)
+		}
+	}
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	svc := iam.New(sess)
+	_, err = svc.UpdateOpenIDConnectProviderThumbprint(&iam.UpdateOpenIDConnectProviderThumbprintInput{
+		OpenIDConnectProviderArn: aws.String(arn),
+		ThumbprintList:           aws.StringSlice(thumbprints),
+	})
+	if err != nil {
+		return fmt.Errorf("can't update thumbprints of %s: %v", arn, err)
+	}
+	log.Info("Updated thumbprints of %s to %v", arn, thumbprints)
+	return nil
+}
+
+// GetRoleTrustPolicy returns the decoded assume role policy document of a role.
+func GetRoleTrustPolicy(profile, roleName string) (string, error) {
+	sess := session.Must(session.NewSessionWithOptions(session.Options{
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	}))
+	svc := iam.New(sess)
+	result, err := svc.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return url.QueryUnescape(aws.StringValue(result.Role.AssumeRolePolicyDocument))
+}
@@ -1,90 +1,281 @@
 package aws
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/shundezhang/oidc-config/pkg/logger"
 )
 
-func UploadToS3(profile, bucket, configPath, configContent, jwksPath, jwksContent string) error {
-	log := logger.NewLogger()
-	sess := session.Must(session.NewSessionWithOptions(session.Options{
-		Profile:           profile,
+// S3Options selects the bucket the OIDC config files are published to.
+type S3Options struct {
+	Profile string
+	// Region of the bucket. If empty, the region of an existing bucket is
+	// looked up, and a new bucket is created in the region of the profile.
+	Region string
+	Bucket string
+	// Publish is how the config is made readable anonymously, PublishACL if
+	// empty.
+	Publish string
+	// DistributionARN is the CloudFront distribution allowed to read the
+	// bucket with PublishCloudFront.
+	DistributionARN string
+	// Endpoint is the URL of an S3 compatible store such as MinIO or Ceph
+	// RGW, AWS S3 if empty.
+	Endpoint string
+	// PathStyle puts the bucket in the path instead of the hostname, which
+	// most S3 compatible stores need.
+	PathStyle bool
+	// CredentialsProfile is the profile to access the bucket with, Profile if
+	// empty.
+	CredentialsProfile string
+	// CABundle is a PEM file of CAs to trust for the endpoint in addition to
+	// the system ones.
+	CABundle string
+}
+
+// cacheControl is how long verifiers and CloudFront may cache the published
+// objects, the same as the other publishers.
+const cacheControl = "public, max-age=300"
+
+const (
+	// PublishACL grants public-read on each object. It fails on buckets with
+	// ACLs disabled, the default for new buckets.
+	PublishACL = "acl"
+	// PublishBucketPolicy allows anonymous reads of the config through the
+	// bucket policy.
+	PublishBucketPolicy = "bucket-policy"
+	// PublishCloudFront keeps the bucket private and serves the config
+	// through a CloudFront distribution.
+	PublishCloudFront = "cloudfront"
+)
+
+var PublishModes = []string{PublishACL, PublishBucketPolicy, PublishCloudFront}
+
+func newS3Client(opts S3Options) (*s3.S3, error) {
+	sessOpts := session.Options{
+		Profile:           opts.Profile,
 		SharedConfigState: session.SharedConfigEnable,
-	}))
+	}
+	if opts.CredentialsProfile != "" {
+		sessOpts.Profile = opts.CredentialsProfile
+	}
+	sess, err := session.NewSessionWithOptions(sessOpts)
+	if err != nil {
+		return nil, err
+	}
+	config := aws.NewConfig()
+	if opts.CABundle != "" {
+		// set on the client, as the CA bundle of the session, from
+		// CustomCABundle or AWS_CA_BUNDLE, replaces the system CAs
+		client, err := newCABundleClient(opts.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		config = config.WithHTTPClient(client)
+	}
+	region := opts.Region
+	if opts.Endpoint != "" {
+		config = config.WithEndpoint(opts.Endpoint).WithS3ForcePathStyle(opts.PathStyle)
+		// S3 compatible stores mostly ignore the region, but requests are
+		// signed with one
+		if region == "" && aws.StringValue(sess.Config.Region) == "" {
+			region = "us-east-1"
+		}
+	} else if region == "" && opts.Bucket != "" {
+		// the global endpoint does not say where the bucket is
+		hint := aws.StringValue(sess.Config.Region)
+		if hint == "" {
+			hint = "us-east-1"
+		}
+		if r, err := s3manager.GetBucketRegion(aws.BackgroundContext(), sess, opts.Bucket, hint); err == nil {
+			region = r
+		}
+	}
+	if region != "" {
+		config = config.WithRegion(region)
+	}
+	return s3.New(sess, config), nil
+}
+
+// newCABundleClient returns an HTTP client that trusts the CAs in a PEM file
+// in addition to the system ones.
+func newCABundleClient(file string) (*http.Client, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	pem, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", file)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// Upload is the result of publishing config and jwks to S3.
+type Upload struct {
+	// ConfigVersion and JWKSVersion are the versions of the objects put,
+	// empty unless the bucket is versioned.
+	ConfigVersion string
+	JWKSVersion   string
+}
+
+func UploadToS3(opts S3Options, configPath, configContent, jwksPath, jwksContent string) (*Upload, error) {
+	log := logger.NewLogger()
+	bucket := opts.Bucket
+	if opts.Publish == "" {
+		opts.Publish = PublishACL
+	}
+	switch opts.Publish {
+	case PublishACL, PublishBucketPolicy:
+	case PublishCloudFront:
+		if opts.Endpoint != "" {
+			return nil, fmt.Errorf("publish mode %s needs AWS S3 and can't be used with endpoint %s", opts.Publish, opts.Endpoint)
+		}
+		if opts.DistributionARN == "" {
+			return nil, fmt.Errorf("publish mode %s requires a distribution", opts.Publish)
+		}
+	default:
+		return nil, fmt.Errorf("publish mode %s not supported, use one of %v", opts.Publish, PublishModes)
+	}
 
 	// Create S3 service client
-	svc := s3.New(sess)
+	svc, err := newS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
 	result, err := svc.ListBuckets(nil)
 	if err != nil {
-		fmt.Printf("Unable to list buckets, %v", err)
-		return err
+		log.Info("Unable to list buckets, %v", err)
+		return nil, err
 	}
 
 	if !bucketExists(bucket, result.Buckets) {
 		log.Info("bucket %s not found, creating it...", bucket)
-		acl := "public-read"
 		newBucket := &s3.CreateBucketInput{
 			Bucket: aws.String(bucket),
-			ACL:    &acl,
+		}
+		if opts.Publish == PublishACL {
+			newBucket.ACL = aws.String(s3.BucketCannedACLPublicRead)
+		}
+		// us-east-1 is the default location and must not be given explicitly
+		if region := aws.StringValue(svc.Config.Region); region != "" && region != "us-east-1" {
+			newBucket.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+				LocationConstraint: aws.String(region),
+			}
 		}
 		result, err := svc.CreateBucket(newBucket)
 		if err != nil {
 			if aerr, ok := err.(awserr.Error); ok {
 				switch aerr.Code() {
 				case s3.ErrCodeBucketAlreadyExists:
-					fmt.Println(s3.ErrCodeBucketAlreadyExists, aerr.Error())
+					log.Info("%s %s", s3.ErrCodeBucketAlreadyExists, aerr.Error())
 				case s3.ErrCodeBucketAlreadyOwnedByYou:
-					fmt.Println(s3.ErrCodeBucketAlreadyOwnedByYou, aerr.Error())
+					log.Info("%s %s", s3.ErrCodeBucketAlreadyOwnedByYou, aerr.Error())
 				default:
-					fmt.Println(aerr.Error())
+					log.Info("%s", aerr.Error())
 				}
 			} else {
 				// Print the error, cast err to awserr.Error to get the Code and
 				// Message from an error.
-				fmt.Println(err.Error())
+				log.Error(err)
+			}
+			return nil, err
+		}
+		log.Info("%v", result)
+		if opts.Publish != PublishACL {
+			if err := enforceBucketOwner(svc, bucket); err != nil {
+				return nil, err
 			}
-			return err
 		}
-		fmt.Println(result)
 	} else {
 		log.Info("bucket %s exists.", bucket)
 	}
-	log.Info("Put config %s to bucket %s...", configPath, bucket)
-	putPublicObject(svc, bucket, configPath, configContent)
-	log.Info("Put jwks %s to bucket %s...", jwksPath, bucket)
-	putPublicObject(svc, bucket, jwksPath, jwksContent)
-	return nil
+	switch opts.Publish {
+	case PublishBucketPolicy:
+		if err := allowPublicRead(svc, bucket, []string{configPath, jwksPath}); err != nil {
+			return nil, err
+		}
+	case PublishCloudFront:
+		if err := allowCloudFrontRead(svc, bucket, []string{configPath, jwksPath}, opts.DistributionARN); err != nil {
+			return nil, err
+		}
+	}
+	return putIssuer(svc, opts, configPath, configContent, jwksPath, jwksContent)
 }
 
-func putPublicObject(svc *s3.S3, bucket string, key string, content string) {
+// RestoreToS3 puts config and jwks that were published before back in place,
+// leaving the bucket and its policy as they are.
+func RestoreToS3(opts S3Options, configPath, configContent, jwksPath, jwksContent string) (*Upload, error) {
+	svc, err := newS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	return putIssuer(svc, opts, configPath, configContent, jwksPath, jwksContent)
+}
+
+func putIssuer(svc *s3.S3, opts S3Options, configPath, configContent, jwksPath, jwksContent string) (*Upload, error) {
+	log := logger.NewLogger()
+	acl := opts.Publish == "" || opts.Publish == PublishACL
+	upload := &Upload{}
+	var err error
+	log.Info("Put config %s to bucket %s...", configPath, opts.Bucket)
+	if upload.ConfigVersion, err = putPublicObject(svc, opts.Bucket, configPath, configContent, acl); err != nil {
+		return nil, err
+	}
+	log.Info("Put jwks %s to bucket %s...", jwksPath, opts.Bucket)
+	if upload.JWKSVersion, err = putPublicObject(svc, opts.Bucket, jwksPath, jwksContent, acl); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// putPublicObject puts an object, granting public-read if acl is set.
+// Otherwise it is readable through the bucket policy. It returns the version
+// of the object, empty unless the bucket is versioned.
+func putPublicObject(svc *s3.S3, bucket string, key string, content string, acl bool) (string, error) {
 	input := &s3.PutObjectInput{
-		ACL:    aws.String("public-read"),
-		Body:   aws.ReadSeekCloser(strings.NewReader(content)),
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Body:        aws.ReadSeekCloser(strings.NewReader(content)),
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/json"),
+		// CloudFront would cache it for a day without
+		CacheControl: aws.String(cacheControl),
 	}
-	result, err := svc.PutObject(input)
+	if acl {
+		input.ACL = aws.String(s3.ObjectCannedACLPublicRead)
+	}
+	output, err := svc.PutObject(input)
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			default:
-				fmt.Println(aerr.Error())
-			}
-		} else {
-			// Print the error, cast err to awserr.Error to get the Code and
-			// Message from an error.
-			fmt.Println(err.Error())
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessControlListNotSupported" {
+			return "", fmt.Errorf("bucket %s has ACLs disabled, publish with the %s mode instead: %v", bucket, PublishBucketPolicy, err)
 		}
-		return
+		return "", fmt.Errorf("can't put %s to bucket %s: %v", key, bucket, err)
 	}
+	return objectVersion(output.VersionId), nil
+}
 
-	fmt.Println(result)
+// objectVersion returns the version S3 reports for an object, or empty if the
+// bucket is not versioned, in which case S3 reports none or "null".
+func objectVersion(v *string) string {
+	if version := aws.StringValue(v); version != "null" {
+		return version
+	}
+	return ""
 }
 func bucketExists(bucket string, buckets []*s3.Bucket) bool {
 	for _, b := range buckets {
@@ -94,3 +285,76 @@ func bucketExists(bucket string, buckets []*s3.Bucket) bool {
 	}
 	return false
 }
+
+// DownloadFromS3 returns the content of an object, or nil if it does not
+// exist.
+func DownloadFromS3(opts S3Options, key string) ([]byte, error) {
+	return DownloadVersionFromS3(opts, key, "")
+}
+
+// DownloadVersionFromS3 returns the content of a version of an object, or the
+// current one if version is empty, or nil if it does not exist.
+func DownloadVersionFromS3(opts S3Options, key string, version string) ([]byte, error) {
+	svc, err := newS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(opts.Bucket),
+		Key:    aws.String(key),
+	}
+	if version != "" {
+		input.VersionId = aws.String(version)
+	}
+	result, err := svc.GetObject(input)
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == s3.ErrCodeNoSuchBucket || aerr.Code() == "NoSuchVersion") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer result.Body.Close()
+	return ioutil.ReadAll(result.Body)
+}
+
+// ListS3Prefixes returns the names of the "directories" directly under
+// prefix, which must end in a slash.
+func ListS3Prefixes(opts S3Options, prefix string) ([]string, error) {
+	svc, err := newS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
+	// keys are put with a leading slash, which the SDK drops from the path
+	prefix = strings.TrimPrefix(prefix, "/")
+	names := []string{}
+	err = svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(opts.Bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range page.CommonPrefixes {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(p.Prefix), prefix), "/"))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// PutPrivateObject puts an object that is not readable anonymously, such as
+// metadata kept next to the published config.
+func PutPrivateObject(opts S3Options, key string, content string) error {
+	svc, err := newS3Client(opts)
+	if err != nil {
+		return err
+	}
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Body:        aws.ReadSeekCloser(strings.NewReader(content)),
+		Bucket:      aws.String(opts.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
@@ -0,0 +1,209 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+)
+
+const (
+	publicReadSid      = "OIDCConfigPublicRead"
+	cloudFrontReadSid  = "OIDCConfigCloudFrontRead"
+	secureTransportSid = "OIDCConfigDenyInsecureTransport"
+)
+
+// enforceBucketOwner disables ACLs on a new bucket, so objects are only made
+// public through the bucket policy.
+func enforceBucketOwner(svc *s3.S3, bucket string) error {
+	_, err := svc.PutBucketOwnershipControls(&s3.PutBucketOwnershipControlsInput{
+		Bucket: aws.String(bucket),
+		OwnershipControls: &s3.OwnershipControls{
+			Rules: []*s3.OwnershipControlsRule{{ObjectOwnership: aws.String(s3.ObjectOwnershipBucketOwnerEnforced)}},
+		},
+	})
+	if err != nil {
+		if notImplemented(err) {
+			// S3 compatible stores without ACLs
+			return nil
+		}
+		return fmt.Errorf("can't set object ownership of bucket %s: %v", bucket, err)
+	}
+	return nil
+}
+
+// allowPublicRead lets anyone get the given keys over TLS through the bucket
+// policy.
+func allowPublicRead(svc *s3.S3, bucket string, keys []string) error {
+	if err := allowPublicPolicy(svc, bucket); err != nil {
+		return err
+	}
+	return putReadPolicy(svc, bucket, keys, map[string]interface{}{
+		"Sid":       publicReadSid,
+		"Effect":    "Allow",
+		"Principal": "*",
+		"Action":    "s3:GetObject",
+	})
+}
+
+// allowCloudFrontRead lets only the CloudFront distribution get the given keys
+// through its origin access control, the bucket stays private.
+func allowCloudFrontRead(svc *s3.S3, bucket string, keys []string, distributionARN string) error {
+	return putReadPolicy(svc, bucket, keys, map[string]interface{}{
+		"Sid":       cloudFrontReadSid,
+		"Effect":    "Allow",
+		"Principal": map[string]string{"Service": "cloudfront.amazonaws.com"},
+		"Action":    "s3:GetObject",
+		"Condition": map[string]interface{}{"StringEquals": map[string]string{"AWS:SourceArn": distributionARN}},
+	})
+}
+
+// putReadPolicy adds a statement that allows reading the given keys to the
+// bucket policy, with a statement denying requests without TLS. Other
+// statements of an existing policy are kept, and the keys are added to those
+// already in a statement with the same Sid, so a bucket can be shared by
+// clusters.
+func putReadPolicy(svc *s3.S3, bucket string, keys []string, read map[string]interface{}) error {
+	policy := map[string]interface{}{}
+	out, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != "NoSuchBucketPolicy" {
+			return fmt.Errorf("can't get policy of bucket %s: %v", bucket, err)
+		}
+	} else if err := json.Unmarshal([]byte(aws.StringValue(out.Policy)), &policy); err != nil {
+		return fmt.Errorf("invalid policy of bucket %s: %v", bucket, err)
+	}
+	arn := fmt.Sprintf("arn:%s:s3:::%s", partition(aws.StringValue(svc.Config.Region)), bucket)
+	resources := map[string]bool{}
+	for _, key := range keys {
+		resources[arn+"/"+strings.TrimPrefix(key, "/")] = true
+	}
+	statements := []interface{}{}
+	if existing, ok := policy["Statement"].([]interface{}); ok {
+		statements = existing
+	} else if existing, ok := policy["Statement"].(map[string]interface{}); ok {
+		statements = []interface{}{existing}
+	}
+	kept := []interface{}{}
+	for _, st := range statements {
+		m, ok := st.(map[string]interface{})
+		if !ok {
+			kept = append(kept, st)
+			continue
+		}
+		switch m["Sid"] {
+		case read["Sid"]:
+			for _, r := range stringOrList(m["Resource"]) {
+				resources[r] = true
+			}
+		case secureTransportSid:
+		default:
+			kept = append(kept, st)
+		}
+	}
+	readable := make([]string, 0, len(resources))
+	for r := range resources {
+		readable = append(readable, r)
+	}
+	sort.Strings(readable)
+	read["Resource"] = readable
+	kept = append(kept, read,
+		map[string]interface{}{
+			"Sid":       secureTransportSid,
+			"Effect":    "Deny",
+			"Principal": "*",
+			"Action":    "s3:*",
+			"Resource":  []string{arn, arn + "/*"},
+			"Condition": map[string]interface{}{"Bool": map[string]string{"aws:SecureTransport": "false"}},
+		})
+	policy["Statement"] = kept
+	if _, ok := policy["Version"]; !ok {
+		policy["Version"] = "2012-10-17"
+	}
+	b, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	_, err = svc.PutBucketPolicy(&s3.PutBucketPolicyInput{Bucket: aws.String(bucket), Policy: aws.String(string(b))})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "AccessDenied" && read["Sid"] == publicReadSid {
+			return fmt.Errorf("can't put policy of bucket %s, check that Block Public Access of the account allows public bucket policies: %v", bucket, err)
+		}
+		return fmt.Errorf("can't put policy of bucket %s: %v", bucket, err)
+	}
+	return nil
+}
+
+// allowPublicPolicy turns off the Block Public Access settings of a bucket
+// that stop a public bucket policy from being put or taking effect. The
+// settings for ACLs are left alone.
+func allowPublicPolicy(svc *s3.S3, bucket string) error {
+	log := logger.NewLogger()
+	out, err := svc.GetPublicAccessBlock(&s3.GetPublicAccessBlockInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchPublicAccessBlockConfiguration" || notImplemented(err) {
+			return nil
+		}
+		return fmt.Errorf("can't get public access block of bucket %s: %v", bucket, err)
+	}
+	config := out.PublicAccessBlockConfiguration
+	changed := []string{}
+	if aws.BoolValue(config.BlockPublicPolicy) {
+		config.BlockPublicPolicy = aws.Bool(false)
+		changed = append(changed, "BlockPublicPolicy")
+	}
+	if aws.BoolValue(config.RestrictPublicBuckets) {
+		config.RestrictPublicBuckets = aws.Bool(false)
+		changed = append(changed, "RestrictPublicBuckets")
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	_, err = svc.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket:                         aws.String(bucket),
+		PublicAccessBlockConfiguration: config,
+	})
+	if err != nil {
+		return fmt.Errorf("can't change public access block of bucket %s: %v", bucket, err)
+	}
+	log.Info("Turned off %s in the public access block of bucket %s", strings.Join(changed, " and "), bucket)
+	return nil
+}
+
+// notImplemented checks if an S3 compatible store does not support an API.
+func notImplemented(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "NotImplemented"
+}
+
+func stringOrList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := []string{}
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// partition returns the ARN partition of a region.
+func partition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	}
+	return "aws"
+}
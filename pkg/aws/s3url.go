@@ -0,0 +1,122 @@
+package aws
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// S3IssuerURL returns the https URL of prefix in a bucket on the regional
+// endpoint. Bucket names with dots don't match the wildcard certificate of
+// virtual hosted style URLs, so path style is used for them.
+func S3IssuerURL(bucket, region, prefix string) string {
+	var u string
+	if strings.Contains(bucket, ".") {
+		u = fmt.Sprintf("https://s3.%s.amazonaws.com/%s", region, bucket)
+	} else {
+		u = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		u += "/" + prefix
+	}
+	return u
+}
+
+// S3EndpointIssuerURL returns the URL of prefix in a bucket on an S3
+// compatible endpoint, in path style if pathStyle is set.
+func S3EndpointIssuerURL(endpoint, bucket, prefix string, pathStyle bool) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint %s: %v", endpoint, err)
+	}
+	if pathStyle {
+		u.Path += "/" + bucket
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		u.Path += "/" + prefix
+	}
+	return u.String(), nil
+}
+
+// S3Location is a path in a bucket, parsed from an S3 URL.
+type S3Location struct {
+	Bucket string
+	// Region of the bucket, empty for the global endpoint.
+	Region string
+	// Prefix is the path in the bucket with a leading but no trailing slash,
+	// or empty for the bucket root.
+	Prefix string
+}
+
+// s3Endpoint matches the part of a hostname before .amazonaws.com: an
+// optional bucket for virtual hosted style followed by s3, s3.<region>,
+// s3-<region> or s3.dualstack.<region>. The bucket group is greedy so dotted
+// bucket names are kept whole.
+var s3Endpoint = regexp.MustCompile(`^(?:(.+)\.)?s3(?:\.dualstack)?(?:[.-]([a-z]{2}(?:-gov|-iso[a-z]*)?-[a-z]+-\d+|external-1))?$`)
+
+// ParseS3URL returns the bucket, region and prefix of an S3 URL in virtual
+// hosted style, e.g. https://bucket.s3.us-west-2.amazonaws.com/prefix, or in
+// path style, e.g. https://s3.us-west-2.amazonaws.com/bucket/prefix.
+func ParseS3URL(u *url.URL) (*S3Location, error) {
+	host := strings.ToLower(u.Hostname())
+	var rest string
+	for _, suffix := range []string{".amazonaws.com", ".amazonaws.com.cn"} {
+		if strings.HasSuffix(host, suffix) {
+			rest = strings.TrimSuffix(host, suffix)
+			break
+		}
+	}
+	m := s3Endpoint.FindStringSubmatch(rest)
+	if m == nil {
+		return nil, fmt.Errorf("URL %s is not an S3 URL", u)
+	}
+	loc := &S3Location{Bucket: m[1], Region: m[2]}
+	if loc.Region == "external-1" {
+		loc.Region = "us-east-1"
+	}
+	return loc, loc.setPath(u)
+}
+
+// ParseS3EndpointURL returns the bucket and prefix of a URL on an S3
+// compatible endpoint, in path style, e.g.
+// https://minio.example.com/bucket/prefix for endpoint
+// https://minio.example.com, or in virtual hosted style, e.g.
+// https://bucket.minio.example.com/prefix.
+func ParseS3EndpointURL(u *url.URL, endpoint string) (*S3Location, error) {
+	ep, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %v", endpoint, err)
+	}
+	host, epHost := strings.ToLower(u.Hostname()), strings.ToLower(ep.Hostname())
+	loc := &S3Location{}
+	switch {
+	case host == epHost:
+	case strings.HasSuffix(host, "."+epHost):
+		loc.Bucket = strings.TrimSuffix(host, "."+epHost)
+	default:
+		return nil, fmt.Errorf("URL %s is not on endpoint %s", u, endpoint)
+	}
+	return loc, loc.setPath(u)
+}
+
+// setPath sets the prefix from the path of u, and the bucket from its first
+// segment for path style URLs.
+func (loc *S3Location) setPath(u *url.URL) error {
+	path := strings.TrimSuffix(u.Path, "/")
+	if loc.Bucket == "" {
+		parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+		if parts[0] == "" {
+			return fmt.Errorf("URL %s has no bucket", u)
+		}
+		loc.Bucket = parts[0]
+		path = ""
+		if len(parts) == 2 {
+			path = "/" + parts[1]
+		}
+	}
+	loc.Prefix = path
+	return nil
+}
@@ -0,0 +1,133 @@
+package aws
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseS3URL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    S3Location
+		invalid bool
+	}{
+		// virtual hosted style
+		{url: "https://bucket.s3.us-west-2.amazonaws.com/cluster1", want: S3Location{Bucket: "bucket", Region: "us-west-2", Prefix: "/cluster1"}},
+		{url: "https://bucket.s3-us-west-2.amazonaws.com/cluster1/", want: S3Location{Bucket: "bucket", Region: "us-west-2", Prefix: "/cluster1"}},
+		{url: "https://bucket.s3.amazonaws.com", want: S3Location{Bucket: "bucket"}},
+		{url: "https://bucket.s3.dualstack.eu-central-1.amazonaws.com/a/b", want: S3Location{Bucket: "bucket", Region: "eu-central-1", Prefix: "/a/b"}},
+		{url: "https://bucket.s3-external-1.amazonaws.com/c", want: S3Location{Bucket: "bucket", Region: "us-east-1", Prefix: "/c"}},
+		{url: "https://bucket.s3.us-gov-west-1.amazonaws.com", want: S3Location{Bucket: "bucket", Region: "us-gov-west-1"}},
+		{url: "https://bucket.s3.cn-north-1.amazonaws.com.cn/c", want: S3Location{Bucket: "bucket", Region: "cn-north-1", Prefix: "/c"}},
+		{url: "https://my.dotted.bucket.s3.us-east-2.amazonaws.com/c", want: S3Location{Bucket: "my.dotted.bucket", Region: "us-east-2", Prefix: "/c"}},
+		{url: "https://Bucket.S3.US-WEST-2.amazonaws.com/Cluster", want: S3Location{Bucket: "bucket", Region: "us-west-2", Prefix: "/Cluster"}},
+		// path style
+		{url: "https://s3.us-west-2.amazonaws.com/bucket/cluster1", want: S3Location{Bucket: "bucket", Region: "us-west-2", Prefix: "/cluster1"}},
+		{url: "https://s3.amazonaws.com/my.dotted.bucket", want: S3Location{Bucket: "my.dotted.bucket"}},
+		{url: "https://s3-us-west-2.amazonaws.com/bucket/", want: S3Location{Bucket: "bucket", Region: "us-west-2"}},
+		{url: "https://s3.dualstack.ap-southeast-2.amazonaws.com/bucket/a/b", want: S3Location{Bucket: "bucket", Region: "ap-southeast-2", Prefix: "/a/b"}},
+		// not S3
+		{url: "https://s3.us-west-2.amazonaws.com/", invalid: true},
+		{url: "https://oidc.example.com/cluster1", invalid: true},
+		{url: "https://bucket.s3.example.com/cluster1", invalid: true},
+		{url: "https://ec2.us-west-2.amazonaws.com/bucket", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loc, err := ParseS3URL(u)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("ParseS3URL() = %+v, want an error", loc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *loc != tt.want {
+				t.Errorf("ParseS3URL() = %+v, want %+v", *loc, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseS3EndpointURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		endpoint string
+		want     S3Location
+		invalid  bool
+	}{
+		{url: "https://minio.example.com/oidc/cluster1", endpoint: "https://minio.example.com", want: S3Location{Bucket: "oidc", Prefix: "/cluster1"}},
+		{url: "https://minio.example.com:9000/oidc", endpoint: "https://minio.example.com:9000/", want: S3Location{Bucket: "oidc"}},
+		{url: "https://oidc.minio.example.com/a/b/", endpoint: "https://minio.example.com", want: S3Location{Bucket: "oidc", Prefix: "/a/b"}},
+		{url: "https://minio.example.com/", endpoint: "https://minio.example.com", invalid: true},
+		{url: "https://other.example.com/oidc", endpoint: "https://minio.example.com", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loc, err := ParseS3EndpointURL(u, tt.endpoint)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("ParseS3EndpointURL() = %+v, want an error", loc)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *loc != tt.want {
+				t.Errorf("ParseS3EndpointURL() = %+v, want %+v", *loc, tt.want)
+			}
+		})
+	}
+}
+
+func TestS3IssuerURL(t *testing.T) {
+	tests := []struct {
+		bucket, region, prefix string
+		want                   string
+	}{
+		{"bucket", "us-west-2", "", "https://bucket.s3.us-west-2.amazonaws.com"},
+		{"bucket", "us-west-2", "/cluster1/", "https://bucket.s3.us-west-2.amazonaws.com/cluster1"},
+		{"my.bucket", "eu-west-1", "c", "https://s3.eu-west-1.amazonaws.com/my.bucket/c"},
+	}
+	for _, tt := range tests {
+		got := S3IssuerURL(tt.bucket, tt.region, tt.prefix)
+		if got != tt.want {
+			t.Errorf("S3IssuerURL(%q, %q, %q) = %s, want %s", tt.bucket, tt.region, tt.prefix, got, tt.want)
+		}
+		// the URL parses back to the same location
+		u, _ := url.Parse(got)
+		loc, err := ParseS3URL(u)
+		if err != nil || loc.Bucket != tt.bucket || loc.Region != tt.region {
+			t.Errorf("ParseS3URL(%s) = %+v, %v", got, loc, err)
+		}
+	}
+}
+
+func TestS3EndpointIssuerURL(t *testing.T) {
+	tests := []struct {
+		endpoint, bucket, prefix string
+		pathStyle                bool
+		want                     string
+	}{
+		{"https://minio.example.com/", "oidc", "cluster1", true, "https://minio.example.com/oidc/cluster1"},
+		{"https://minio.example.com", "oidc", "", false, "https://oidc.minio.example.com"},
+		{"http://localhost:9000", "oidc", "/a/b/", true, "http://localhost:9000/oidc/a/b"},
+	}
+	for _, tt := range tests {
+		got, err := S3EndpointIssuerURL(tt.endpoint, tt.bucket, tt.prefix, tt.pathStyle)
+		if err != nil || got != tt.want {
+			t.Errorf("S3EndpointIssuerURL(%q, %q, %q, %v) = %s, %v, want %s", tt.endpoint, tt.bucket, tt.prefix, tt.pathStyle, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/metrics"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/publish"
+	"github.com/shundezhang/oidc-config/pkg/util"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Controller keeps the published config and jwks in sync with the cluster.
+type Controller struct {
+	Config     *rest.Config
+	Publishers []publish.Publisher
+	// IssuerURL rewrites the issuer of the cluster if set.
+	IssuerURL string
+	// Profile is the AWS profile for IAM, empty for the credentials of the
+	// pod, e.g. from IRSA.
+	Profile string
+	// UpdateThumbprint keeps the thumbprints of the OIDC provider of the
+	// issuer in IAM up to date with its certificate.
+	UpdateThumbprint bool
+	// Verify fetches the published jwks after each sync and fails the sync
+	// if it misses keys of the cluster.
+	Verify bool
+	// WebhookNamespace and WebhookName select the pod identity webhook
+	// whose availability is reported, not checked if WebhookName is empty.
+	WebhookNamespace string
+	WebhookName      string
+
+	// published are the documents published last, nil before the first sync
+	published *oidc.Issuer
+	// publishedURL is the issuer URL they were published at
+	publishedURL string
+	// thumbprintUpdated is when the controller last updated the thumbprint
+	// of the OIDC provider providerARN
+	thumbprintUpdated time.Time
+	providerARN       string
+}
+
+// Sync publishes the config and jwks if they changed since the last sync, and
+// reports the certificate of the issuer and the thumbprint of its OIDC
+// provider, which it updates with UpdateThumbprint. The first sync always
+// publishes, as the copy published before the controller started may be
+// stale. The certificate is reported even if syncing the keys failed, e.g.
+// while a CDN still serves a cached jwks. Errors are counted by stage in
+// metrics.
+func (c *Controller) Sync(ctx context.Context) error {
+	err := c.syncKeys(ctx)
+	issuerURL := c.publishedURL
+	if issuerURL == "" {
+		issuerURL = c.IssuerURL
+	}
+	if issuerURL == "" {
+		return err
+	}
+	if certErr := c.syncCertificate(issuerURL); certErr != nil && err == nil {
+		err = certErr
+	}
+	return err
+}
+
+func (c *Controller) syncKeys(ctx context.Context) error {
+	log := logger.NewLogger()
+	issuer, err := c.fetch(ctx)
+	if err != nil {
+		metrics.SyncErrors.WithLabelValues(metrics.StageFetch).Inc()
+		return err
+	}
+	metrics.ClusterKeys.Set(float64(len(issuer.JWKS.Keys)))
+	if c.published == nil || !bytes.Equal(c.published.RawJWKS, issuer.RawJWKS) || !bytes.Equal(c.published.RawDiscovery, issuer.RawDiscovery) {
+		if c.published != nil {
+			diff := oidc.DiffJWKS(issuer.JWKS, c.published.JWKS)
+			log.Info("Key set changed, added %v, removed %v, changed %v", diff.Added, diff.Removed, diff.Changed)
+		}
+		published := issuer
+		for _, p := range c.Publishers {
+			if published, err = p.Publish(ctx, published); err != nil {
+				metrics.SyncErrors.WithLabelValues(metrics.StageUpload).Inc()
+				return err
+			}
+		}
+		c.published = issuer
+		c.publishedURL = published.Discovery.Issuer
+		log.Info("Published issuer %s with keys %v", published.Discovery.Issuer, issuer.JWKS.KeyIDs())
+	}
+	if c.Verify {
+		if err := c.verify(ctx, issuer); err != nil {
+			metrics.SyncErrors.WithLabelValues(metrics.StageVerify).Inc()
+			return err
+		}
+	}
+	metrics.LastSyncSuccess.SetToCurrentTime()
+	return nil
+}
+
+func (c *Controller) fetch(ctx context.Context) (*oidc.Issuer, error) {
+	issuer, err := oidc.Fetch(ctx, c.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err := issuer.Check(); err != nil {
+		return nil, err
+	}
+	if c.IssuerURL != "" {
+		if err := issuer.CheckTokenIssuer(c.IssuerURL); err != nil {
+			return nil, err
+		}
+		return issuer.Rewrite(c.IssuerURL)
+	}
+	return issuer, nil
+}
+
+// verify checks that the jwks at the public issuer URL has every key of the
+// cluster. It may have more, e.g. retired keys kept by --merge-keys.
+func (c *Controller) verify(ctx context.Context, issuer *oidc.Issuer) error {
+	published, err := oidc.FetchPublic(ctx, c.publishedURL)
+	if err != nil {
+		return err
+	}
+	metrics.PublishedKeys.WithLabelValues(c.publishedURL).Set(float64(len(published.JWKS.Keys)))
+	diff := oidc.DiffJWKS(issuer.JWKS, published.JWKS)
+	if len(diff.Added) > 0 || len(diff.Changed) > 0 {
+		return fmt.Errorf("jwks published at %s is stale, missing %v, changed %v", c.publishedURL, diff.Added, diff.Changed)
+	}
+	return nil
+}
+
+// syncCertificate reports the expiry of the certificate of the issuer, and
+// whether the thumbprints of its OIDC provider include the one of the top CA
+// of its chain. With UpdateThumbprint it adds the thumbprint when it is not
+// the first one.
+func (c *Controller) syncCertificate(issuerURL string) error {
+	chain, err := aws.GetCertificateChain(issuerURL)
+	if err != nil {
+		metrics.SyncErrors.WithLabelValues(metrics.StageCertificate).Inc()
+		return err
+	}
+	metrics.CertificateExpiryDays.WithLabelValues(issuerURL).Set(time.Until(chain[0].NotAfter).Hours() / 24)
+	if err := c.syncThumbprint(issuerURL, aws.Thumbprint(aws.TopCA(chain))); err != nil {
+		metrics.SyncErrors.WithLabelValues(metrics.StageThumbprint).Inc()
+		return err
+	}
+	return nil
+}
+
+func (c *Controller) syncThumbprint(issuerURL, thumbprint string) error {
+	u, err := url.Parse(issuerURL)
+	if err != nil {
+		return err
+	}
+	arn, err := aws.GetOIDCProviderARN(c.Profile, u.Hostname()+u.Path)
+	if err != nil {
+		return err
+	}
+	provider, err := aws.GetOIDCProvider(c.Profile, arn)
+	if err != nil {
+		return err
+	}
+	// IAM does not say when thumbprints changed, only when the controller
+	// changed them is known
+	updated := provider.CreateDate
+	if arn == c.providerARN {
+		updated = c.thumbprintUpdated
+	}
+	matches := util.Contains(provider.Thumbprints, thumbprint)
+	if c.UpdateThumbprint && (len(provider.Thumbprints) == 0 || provider.Thumbprints[0] != thumbprint) {
+		if err := aws.AddOIDCProviderThumbprint(c.Profile, arn, thumbprint); err != nil {
+			metrics.ThumbprintMatches.WithLabelValues(arn).Set(boolValue(matches))
+			return err
+		}
+		updated, matches = time.Now(), true
+		c.providerARN, c.thumbprintUpdated = arn, updated
+	}
+	metrics.ThumbprintMatches.WithLabelValues(arn).Set(boolValue(matches))
+	metrics.ThumbprintAge.WithLabelValues(arn).Set(time.Since(updated).Seconds())
+	return nil
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// checkWebhook reports whether the pod identity webhook is available.
+func (c *Controller) checkWebhook(ctx context.Context) {
+	if c.WebhookName == "" {
+		return
+	}
+	log := logger.NewLogger()
+	k, err := kubernetes.NewForConfig(c.Config)
+	if err == nil {
+		err = k8s.CheckWebhook(ctx, k, c.WebhookNamespace, c.WebhookName)
+	}
+	if err != nil {
+		log.Info("Pod identity webhook is not available: %v", err)
+		metrics.WebhookAvailable.WithLabelValues(c.WebhookNamespace, c.WebhookName).Set(0)
+		return
+	}
+	metrics.WebhookAvailable.WithLabelValues(c.WebhookNamespace, c.WebhookName).Set(1)
+}
+
+// Run syncs every interval until the context is done. A failed sync is
+// retried at the next interval.
+func (c *Controller) Run(ctx context.Context, interval time.Duration) {
+	log := logger.NewLogger()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := c.Sync(ctx); err != nil {
+			log.Info("Sync failed, retrying in %s: %v", interval, err)
+		}
+		c.checkWebhook(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"bytes"
+	"text/template"
+)
+
+// DeployOptions selects how the controller is deployed.
+type DeployOptions struct {
+	Namespace      string
+	ServiceAccount string
+	Image          string
+	// RoleARN is the IAM role the controller assumes through IRSA.
+	RoleARN string
+	// Args are the arguments of the controller command.
+	Args []string
+	// ConfigMaps and Workloads grant what the configmap and nginx
+	// publishers need.
+	ConfigMaps bool
+	Workloads  bool
+	// Webhook grants reading the pod identity webhook to report its
+	// availability.
+	Webhook bool
+	// MetricsPort is the port metrics are served on, 0 if disabled.
+	MetricsPort int
+}
+
+// deployTemplate runs a single replica, as two controllers would publish
+// concurrently.
+var deployTemplate = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Namespace }}
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: {{ .ServiceAccount }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: oidc-config-controller
+    app.kubernetes.io/managed-by: oidc-config
+{{- if .RoleARN }}
+  annotations:
+    eks.amazonaws.com/role-arn: {{ .RoleARN }}
+    eks.amazonaws.com/audience: sts.amazonaws.com
+    eks.amazonaws.com/sts-regional-endpoints: "true"
+    eks.amazonaws.com/token-expiration: "86400"
+{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: oidc-config-controller-issuer-discovery
+  labels:
+    app.kubernetes.io/name: oidc-config-controller
+    app.kubernetes.io/managed-by: oidc-config
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:service-account-issuer-discovery
+subjects:
+- kind: ServiceAccount
+  name: {{ .ServiceAccount }}
+  namespace: {{ .Namespace }}
+{{- if or .ConfigMaps .Workloads .Webhook }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: oidc-config-controller
+  labels:
+    app.kubernetes.io/name: oidc-config-controller
+    app.kubernetes.io/managed-by: oidc-config
+rules:
+{{- if .ConfigMaps }}
+- apiGroups: [""]
+  resources: ["configmaps"]
+  verbs: ["get", "create", "update"]
+{{- end }}
+{{- if .Webhook }}
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["get"]
+- apiGroups: ["admissionregistration.k8s.io"]
+  resources: ["mutatingwebhookconfigurations"]
+  verbs: ["get"]
+{{- end }}
+{{- if .Workloads }}
+- apiGroups: [""]
+  resources: ["services"]
+  verbs: ["get", "create", "update"]
+- apiGroups: ["apps"]
+  resources: ["deployments"]
+  verbs: ["get", "create", "update"]
+- apiGroups: ["networking.k8s.io"]
+  resources: ["ingresses"]
+  verbs: ["get", "create", "update"]
+- apiGroups: ["cert-manager.io"]
+  resources: ["certificates"]
+  verbs: ["get", "create", "update"]
+{{- end }}
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: oidc-config-controller
+  labels:
+    app.kubernetes.io/name: oidc-config-controller
+    app.kubernetes.io/managed-by: oidc-config
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: oidc-config-controller
+subjects:
+- kind: ServiceAccount
+  name: {{ .ServiceAccount }}
+  namespace: {{ .Namespace }}
+{{- end }}
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: oidc-config-controller
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: oidc-config-controller
+    app.kubernetes.io/managed-by: oidc-config
+spec:
+  replicas: 1
+  strategy:
+    type: Recreate
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: oidc-config-controller
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: oidc-config-controller
+{{- if .MetricsPort }}
+      annotations:
+        prometheus.io/scrape: "true"
+        prometheus.io/port: "{{ .MetricsPort }}"
+        prometheus.io/path: /metrics
+{{- end }}
+    spec:
+      serviceAccountName: {{ .ServiceAccount }}
+      containers:
+      - name: controller
+        image: {{ .Image }}
+        args:
+        - controller
+{{- range .Args }}
+        - {{ printf "%q" . }}
+{{- end }}
+{{- if .MetricsPort }}
+        ports:
+        - name: metrics
+          containerPort: {{ .MetricsPort }}
+{{- end }}
+`
+
+// Render returns the manifests of the controller, its service account and
+// RBAC.
+func Render(opts DeployOptions) ([]byte, error) {
+	t, err := template.New("").Parse(deployTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, opts); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,265 @@
+package doctor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+type Status string
+
+const (
+	Pass Status = "PASS"
+	Warn Status = "WARN"
+	Fail Status = "FAIL"
+
+	roleARNAnnotation = "eks.amazonaws.com/role-arn"
+)
+
+// defaultIssuers are issuers kube-apiserver is commonly run with, which are
+// not reachable from outside the cluster.
+var defaultIssuers = []string{
+	"https://kubernetes.default.svc",
+	"https://kubernetes.default.svc.cluster.local",
+}
+
+// Result is the outcome of one check, with a hint on how to fix it.
+type Result struct {
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+type Report []Result
+
+func (r Report) Failed() bool {
+	for i := range r {
+		if r[i].Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// Options selects what the doctor checks.
+type Options struct {
+	Getter  genericclioptions.RESTClientGetter
+	Profile string
+	// WebhookNamespace and WebhookName are the namespace and name of the pod
+	// identity webhook Deployment and MutatingWebhookConfiguration.
+	WebhookNamespace string
+	WebhookName      string
+	// ServiceAccount and Namespace select a service account whose role is
+	// checked, skipped if ServiceAccount is empty.
+	ServiceAccount string
+	Namespace      string
+}
+
+type doctor struct {
+	Options
+	report Report
+	client kubernetes.Interface
+}
+
+func (d *doctor) add(name string, status Status, hint string, msg string, args ...interface{}) {
+	d.report = append(d.report, Result{Name: name, Status: status, Message: fmt.Sprintf(msg, args...), Hint: hint})
+}
+
+// Run checks every link from the API server to the IAM role of a service
+// account.
+func Run(ctx context.Context, o Options) Report {
+	d := &doctor{Options: o}
+	config, err := k8s.GetKubernetesConfig(o.Getter)
+	if err != nil {
+		d.add("cluster", Fail, "check --kubeconfig and --context", "can't load kubeconfig: %v", err)
+		return d.report
+	}
+	d.client, err = k8s.GetKubernetesClient(o.Getter)
+	if err != nil {
+		d.add("cluster", Fail, "check --kubeconfig and --context", "can't create client: %v", err)
+		return d.report
+	}
+	issuer, err := oidc.Fetch(ctx, config)
+	if err != nil {
+		d.add("cluster-issuer", Fail,
+			"the API server must serve /.well-known/openid-configuration and /openid/v1/jwks; check that your user is allowed to get them",
+			"can't get oidc config from the API server: %v", err)
+	} else if d.checkIssuer(issuer) {
+		d.checkPublished(ctx, issuer)
+		if arn := d.checkOIDCProvider(issuer); arn != "" {
+			d.checkServiceAccount(ctx, issuer, arn)
+		}
+	}
+	d.checkWebhook(ctx)
+	return d.report
+}
+
+func (d *doctor) checkIssuer(issuer *oidc.Issuer) bool {
+	iss := strings.TrimSuffix(issuer.Discovery.Issuer, "/")
+	for _, def := range defaultIssuers {
+		if iss == def {
+			d.add("cluster-issuer", Fail,
+				"set --service-account-issuer on the API server to the public URL the config is published at, see print-apiserver-config",
+				"the API server uses the default issuer %s, which AWS STS can't reach", iss)
+			return false
+		}
+	}
+	if !strings.HasPrefix(iss, "https://") {
+		d.add("cluster-issuer", Fail, "set --service-account-issuer to an https URL", "issuer %s is not https", iss)
+		return false
+	}
+	d.add("cluster-issuer", Pass, "", "the API server issuer is %s", iss)
+	return true
+}
+
+func (d *doctor) checkPublished(ctx context.Context, issuer *oidc.Issuer) {
+	iss := strings.TrimSuffix(issuer.Discovery.Issuer, "/")
+	hint := "publish the config with get --upload-to-s3"
+	raw, err := k8s.GetPublicURL(ctx, iss+oidc.DiscoveryPath)
+	if err != nil {
+		d.add("published-config", Fail, hint+"; objects must be readable anonymously", "can't get %s%s anonymously: %v", iss, oidc.DiscoveryPath, err)
+		return
+	}
+	published, err := oidc.ParseDiscoveryDocument(raw)
+	if err != nil {
+		d.add("published-config", Fail, hint, "%s%s: %v", iss, oidc.DiscoveryPath, err)
+		return
+	}
+	if published.Issuer != issuer.Discovery.Issuer {
+		d.add("published-config", Fail, hint, "published issuer %q does not match the API server issuer %q", published.Issuer, issuer.Discovery.Issuer)
+		return
+	}
+	d.add("published-config", Pass, "", "%s%s is readable anonymously", iss, oidc.DiscoveryPath)
+
+	rawJWKS, err := k8s.GetPublicURL(ctx, published.JWKSURI)
+	if err != nil {
+		d.add("published-jwks", Fail, hint+"; objects must be readable anonymously", "can't get %s anonymously: %v", published.JWKSURI, err)
+		return
+	}
+	if bytes.Equal(rawJWKS, issuer.RawJWKS) {
+		d.add("published-jwks", Pass, "", "%s is identical to the API server jwks", published.JWKSURI)
+		return
+	}
+	jwks, err := oidc.ParseJWKS(rawJWKS)
+	if err != nil {
+		d.add("published-jwks", Fail, hint, "%s: %v", published.JWKSURI, err)
+		return
+	}
+	for _, kid := range issuer.JWKS.KeyIDs() {
+		if jwks.Key(kid) == nil {
+			d.add("published-jwks", Fail, hint+"; the signing key has probably been rotated",
+				"%s does not have key %s the API server signs with", published.JWKSURI, kid)
+			return
+		}
+	}
+	d.add("published-jwks", Warn, hint, "%s has all keys of the API server jwks but is not identical to it", published.JWKSURI)
+}
+
+// checkOIDCProvider checks the OIDC provider in IAM and returns its ARN if
+// it exists.
+func (d *doctor) checkOIDCProvider(issuer *oidc.Issuer) string {
+	u, err := issuer.Discovery.IssuerURL()
+	if err != nil {
+		d.add("oidc-provider", Fail, "", "%v", err)
+		return ""
+	}
+	hint := "create it with get --create-oidc-provider"
+	arn, err := aws.GetOIDCProviderARN(d.Profile, u.Hostname()+u.Path)
+	if err != nil {
+		d.add("oidc-provider", Fail, hint, "%v", err)
+		return ""
+	}
+	provider, err := aws.GetOIDCProvider(d.Profile, arn)
+	if err != nil {
+		d.add("oidc-provider", Fail, hint, "can't get %s: %v", arn, err)
+		return ""
+	}
+	if !util.Contains(provider.ClientIDs, "sts.amazonaws.com") {
+		d.add("oidc-provider", Fail, "add sts.amazonaws.com with aws iam add-client-id-to-open-id-connect-provider",
+			"%s does not have client id sts.amazonaws.com, only %v", arn, provider.ClientIDs)
+		return arn
+	}
+	d.add("oidc-provider", Pass, "", "%s exists with client id sts.amazonaws.com", arn)
+
+	thumbprint, err := aws.GetThumbprint(issuer.Discovery.Issuer)
+	if err != nil {
+		d.add("oidc-provider-thumbprint", Warn, "", "can't get the certificate of %s: %v", issuer.Discovery.Issuer, err)
+	} else if !util.Contains(provider.Thumbprints, thumbprint) {
+		d.add("oidc-provider-thumbprint", Warn,
+			fmt.Sprintf("aws iam update-open-id-connect-provider-thumbprint --open-id-connect-provider-arn %s --thumbprint-list %s", arn, thumbprint),
+			"thumbprints %v of %s don't include %s of the certificate served now", provider.Thumbprints, arn, thumbprint)
+	} else {
+		d.add("oidc-provider-thumbprint", Pass, "", "thumbprint %s matches the certificate of %s", thumbprint, u.Host)
+	}
+	return arn
+}
+
+func (d *doctor) checkServiceAccount(ctx context.Context, issuer *oidc.Issuer, providerARN string) {
+	if d.ServiceAccount == "" {
+		return
+	}
+	name := "service-account"
+	sa, err := d.client.CoreV1().ServiceAccounts(d.Namespace).Get(ctx, d.ServiceAccount, metav1.GetOptions{})
+	if err != nil {
+		d.add(name, Fail, "create it with create-role --create-sa", "can't get service account %s/%s: %v", d.Namespace, d.ServiceAccount, err)
+		return
+	}
+	roleARN := sa.Annotations[roleARNAnnotation]
+	if roleARN == "" {
+		d.add(name, Fail, "annotate it with "+roleARNAnnotation, "service account %s/%s has no %s annotation", d.Namespace, d.ServiceAccount, roleARNAnnotation)
+		return
+	}
+	d.add(name, Pass, "", "service account %s/%s uses role %s", d.Namespace, d.ServiceAccount, roleARN)
+
+	name = "role-trust-policy"
+	roleName := roleARN[strings.LastIndex(roleARN, "/")+1:]
+	policy, err := aws.GetRoleTrustPolicy(d.Profile, roleName)
+	if err != nil {
+		d.add(name, Fail, "check the "+roleARNAnnotation+" annotation", "can't get role %s: %v", roleName, err)
+		return
+	}
+	u, _ := issuer.Discovery.IssuerURL()
+	if err := checkTrustPolicy(policy, providerARN, u.Hostname()+u.Path, d.Namespace, d.ServiceAccount); err != nil {
+		d.add(name, Fail, "recreate the role with create-role, or fix its trust policy", "role %s: %v", roleName, err)
+		return
+	}
+	d.add(name, Pass, "", "role %s trusts %s/%s through %s", roleName, d.Namespace, d.ServiceAccount, providerARN)
+}
+
+func (d *doctor) checkWebhook(ctx context.Context) {
+	hint := "install it with deploy-webhook"
+	name := "webhook-deployment"
+	describe := fmt.Sprintf("kubectl -n %s describe deployment %s", d.WebhookNamespace, d.WebhookName)
+	switch available, want, err := k8s.WebhookReplicas(ctx, d.client, d.WebhookNamespace, d.WebhookName); {
+	case err != nil:
+		d.add(name, Fail, hint, "%v", err)
+	case available == 0:
+		d.add(name, Fail, describe, "deployment %s/%s has no available replicas", d.WebhookNamespace, d.WebhookName)
+	case available < want:
+		d.add(name, Warn, describe, "deployment %s/%s has %d of %d replicas available", d.WebhookNamespace, d.WebhookName, available, want)
+	default:
+		d.add(name, Pass, "", "deployment %s/%s has %d replicas available", d.WebhookNamespace, d.WebhookName, available)
+	}
+
+	name = "webhook-configuration"
+	webhooks, err := k8s.CheckWebhookConfiguration(ctx, d.client, d.WebhookName)
+	if errors.Is(err, k8s.ErrNoCABundle) {
+		hint = "check that cert-manager is running and injects the CA bundle"
+	}
+	if err != nil {
+		d.add(name, Fail, hint, "%v", err)
+		return
+	}
+	d.add(name, Pass, "", "mutatingwebhookconfiguration %s has %d webhooks with a CA bundle", d.WebhookName, webhooks)
+}
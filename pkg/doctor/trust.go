@@ -0,0 +1,141 @@
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/util"
+)
+
+// stringOrSlice is an IAM policy value that is either a string or a list.
+type stringOrSlice []string
+
+func (s *stringOrSlice) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*s = []string{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+// principal is the Principal of a statement, principals by type or "*" for
+// anyone, which is kept under the type "*".
+type principal map[string]stringOrSlice
+
+func (p *principal) UnmarshalJSON(b []byte) error {
+	var anyone string
+	if err := json.Unmarshal(b, &anyone); err == nil {
+		if anyone != "*" {
+			return fmt.Errorf("invalid principal %q", anyone)
+		}
+		*p = principal{"*": {"*"}}
+		return nil
+	}
+	var byType map[string]stringOrSlice
+	if err := json.Unmarshal(b, &byType); err != nil {
+		return err
+	}
+	*p = byType
+	return nil
+}
+
+// federated reports whether the principal includes the OIDC provider.
+func (p principal) federated(providerARN string) bool {
+	return len(p["*"]) > 0 || util.Contains(p["Federated"], providerARN)
+}
+
+type statement struct {
+	Effect    string                              `json:"Effect"`
+	Principal principal                           `json:"Principal"`
+	Action    stringOrSlice                       `json:"Action"`
+	Condition map[string]map[string]stringOrSlice `json:"Condition"`
+}
+
+// statements is the Statement of a policy, which is either a single
+// statement or a list.
+type statements []statement
+
+func (s *statements) UnmarshalJSON(b []byte) error {
+	var one statement
+	if err := json.Unmarshal(b, &one); err == nil {
+		*s = []statement{one}
+		return nil
+	}
+	var many []statement
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*s = many
+	return nil
+}
+
+type policyDocument struct {
+	Statement statements `json:"Statement"`
+}
+
+// checkTrustPolicy checks that a role's trust policy lets the service account
+// assume it with a token from the OIDC provider. issuer is the provider URL
+// without scheme, which IAM uses as prefix of the condition keys.
+func checkTrustPolicy(policy, providerARN, issuer, namespace, sa string) error {
+	doc := &policyDocument{}
+	if err := json.Unmarshal([]byte(policy), doc); err != nil {
+		return fmt.Errorf("invalid trust policy: %v", err)
+	}
+	sub := fmt.Sprintf("system:serviceaccount:%s:%s", namespace, sa)
+	for _, st := range doc.Statement {
+		if st.Effect != "Allow" || !matchesAny(st.Action, "sts:AssumeRoleWithWebIdentity") {
+			continue
+		}
+		if !st.Principal.federated(providerARN) {
+			continue
+		}
+		if conditionAllows(st.Condition, issuer+":sub", sub) && conditionAllows(st.Condition, issuer+":aud", "sts.amazonaws.com") {
+			return nil
+		}
+	}
+	return fmt.Errorf("no statement allows %s to assume the role with sts:AssumeRoleWithWebIdentity from %s", sub, providerARN)
+}
+
+// conditionAllows checks the StringEquals and StringLike conditions on key. A
+// key without conditions allows any value.
+func conditionAllows(conditions map[string]map[string]stringOrSlice, key, value string) bool {
+	for op, keys := range conditions {
+		values, ok := keys[key]
+		if !ok {
+			continue
+		}
+		switch op {
+		case "StringEquals":
+			if !util.Contains(values, value) {
+				return false
+			}
+		case "StringLike":
+			if !matchesAny(values, value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchesAny matches value against IAM wildcard patterns, where * matches
+// any characters and ? a single one.
+func matchesAny(patterns []string, value string) bool {
+	for _, p := range patterns {
+		expr := regexp.QuoteMeta(p)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		if regexp.MustCompile("^" + expr + "$").MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package doctor
+
+import "testing"
+
+func TestCheckTrustPolicy(t *testing.T) {
+	const (
+		providerARN = "arn:aws:iam::123456789012:oidc-provider/oidc.example.com/cluster1"
+		issuer      = "oidc.example.com/cluster1"
+	)
+	tests := []struct {
+		name   string
+		policy string
+		ok     bool
+	}{
+		{
+			name: "statement list",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRoleWithWebIdentity",
+				"Principal": {"Federated": "` + providerARN + `"},
+				"Condition": {"StringEquals": {"` + issuer + `:sub": "system:serviceaccount:default:app", "` + issuer + `:aud": "sts.amazonaws.com"}}}]}`,
+			ok: true,
+		},
+		{
+			name: "single statement with StringLike",
+			policy: `{"Statement": {"Effect": "Allow", "Action": ["sts:AssumeRole*"],
+				"Principal": {"Federated": ["` + providerARN + `"]},
+				"Condition": {"StringLike": {"` + issuer + `:sub": "system:serviceaccount:default:*"}}}}`,
+			ok: true,
+		},
+		{
+			name: "any principal",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRoleWithWebIdentity", "Principal": "*",
+				"Condition": {"StringEquals": {"` + issuer + `:sub": "system:serviceaccount:default:app"}}}]}`,
+			ok: true,
+		},
+		{
+			name: "other provider",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRoleWithWebIdentity",
+				"Principal": {"Federated": "arn:aws:iam::123456789012:oidc-provider/oidc.example.com/cluster2"}}]}`,
+		},
+		{
+			name: "other service account",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRoleWithWebIdentity",
+				"Principal": {"Federated": "` + providerARN + `"},
+				"Condition": {"StringEquals": {"` + issuer + `:sub": "system:serviceaccount:default:other"}}}]}`,
+		},
+		{
+			name: "denied",
+			policy: `{"Statement": [{"Effect": "Deny", "Action": "sts:AssumeRoleWithWebIdentity",
+				"Principal": {"Federated": "` + providerARN + `"}}]}`,
+		},
+		{
+			name:   "service principal",
+			policy: `{"Statement": [{"Effect": "Allow", "Action": "sts:AssumeRole", "Principal": {"Service": "ec2.amazonaws.com"}}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTrustPolicy(tt.policy, providerARN, issuer, "default", "app")
+			if tt.ok && err != nil {
+				t.Errorf("checkTrustPolicy() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("checkTrustPolicy() = nil, want an error")
+			}
+		})
+	}
+}
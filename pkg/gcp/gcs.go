@@ -0,0 +1,301 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// DefaultEndpoint is the endpoint of Google Cloud Storage, which also
+	// serves public objects.
+	DefaultEndpoint = "https://storage.googleapis.com"
+
+	fullControlScope = "https://www.googleapis.com/auth/devstorage.full_control"
+	publicReadRole   = "roles/storage.objectViewer"
+	allUsers         = "allUsers"
+	// cacheControl is how long verifiers may cache the objects, the same as
+	// the other publishers. GCS caches public objects for an hour otherwise.
+	cacheControl = "public, max-age=300"
+)
+
+// GCSOptions selects the bucket the OIDC config files are published to.
+type GCSOptions struct {
+	Bucket string
+	// Project and Location are where a missing bucket is created.
+	Project  string
+	Location string
+	// Endpoint is the URL of the storage API, DefaultEndpoint if empty.
+	Endpoint string
+	// CredentialsFile is a service account key file, the application
+	// default credentials are used if empty.
+	CredentialsFile string
+	// Anonymous sends requests without credentials, e.g. to a fake GCS
+	// server.
+	Anonymous bool
+}
+
+func (o GCSOptions) endpoint() string {
+	if o.Endpoint == "" {
+		return DefaultEndpoint
+	}
+	return strings.TrimSuffix(o.Endpoint, "/")
+}
+
+// GCSIssuerURL returns the issuer URL of config published in a bucket, in the
+// https://storage.googleapis.com/<bucket>/<prefix> form.
+func GCSIssuerURL(endpoint, bucket, prefix string) string {
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	u := strings.TrimSuffix(endpoint, "/") + "/" + bucket
+	if p := strings.Trim(prefix, "/"); p != "" {
+		u += "/" + p
+	}
+	return u
+}
+
+type gcsClient struct {
+	http     *http.Client
+	endpoint string
+	// project is where buckets are created, from the options or else the
+	// credentials.
+	project string
+}
+
+func newGCSClient(ctx context.Context, opts GCSOptions) (*gcsClient, error) {
+	c := &gcsClient{http: http.DefaultClient, endpoint: opts.endpoint(), project: opts.Project}
+	if opts.Anonymous {
+		return c, nil
+	}
+	if opts.CredentialsFile != "" {
+		b, err := ioutil.ReadFile(opts.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		creds, err := google.CredentialsFromJSON(ctx, b, fullControlScope)
+		if err != nil {
+			return nil, fmt.Errorf("can't read credentials %s: %v", opts.CredentialsFile, err)
+		}
+		c.useCredentials(ctx, creds)
+		return c, nil
+	}
+	creds, err := google.FindDefaultCredentials(ctx, fullControlScope)
+	if err != nil {
+		return nil, fmt.Errorf("can't find Google Cloud credentials, run gcloud auth application-default login or set GOOGLE_APPLICATION_CREDENTIALS: %v", err)
+	}
+	c.useCredentials(ctx, creds)
+	return c, nil
+}
+
+func (c *gcsClient) useCredentials(ctx context.Context, creds *google.Credentials) {
+	c.http = oauth2.NewClient(ctx, creds.TokenSource)
+	if c.project == "" {
+		c.project = creds.ProjectID
+	}
+}
+
+// gcsError is the error body of the JSON API.
+type gcsError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// do sends a request and decodes a JSON response into out if not nil. It
+// returns the status code, and an error for any status but 2xx.
+func (c *gcsClient) do(ctx context.Context, method, path string, query url.Values, contentType string, body io.Reader, out interface{}) (int, error) {
+	u := c.endpoint + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return 0, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var e gcsError
+		if json.Unmarshal(b, &e) == nil && e.Error.Message != "" {
+			return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, e.Error.Message)
+		}
+		return resp.StatusCode, fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil && len(b) > 0 {
+		return resp.StatusCode, json.Unmarshal(b, out)
+	}
+	return resp.StatusCode, nil
+}
+
+func (c *gcsClient) doJSON(ctx context.Context, method, path string, query url.Values, in, out interface{}) (int, error) {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+	return c.do(ctx, method, path, query, "application/json", body, out)
+}
+
+// UploadToGCS puts the config and jwks into the bucket, creating it if
+// missing, and makes its objects readable by anyone through IAM.
+func UploadToGCS(ctx context.Context, opts GCSOptions, configPath, configContent, jwksPath, jwksContent string) error {
+	log := logger.NewLogger()
+	c, err := newGCSClient(ctx, opts)
+	if err != nil {
+		return err
+	}
+	bucketPath := "/storage/v1/b/" + url.PathEscape(opts.Bucket)
+	status, err := c.doJSON(ctx, http.MethodGet, bucketPath, nil, nil, nil)
+	switch {
+	case status == http.StatusNotFound:
+		if err := createBucket(ctx, c, opts.Bucket, opts.Location); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("can't get bucket %s: %v", opts.Bucket, err)
+	default:
+		log.Info("bucket %s exists.", opts.Bucket)
+	}
+	if err := allowAllUsersRead(ctx, c, opts.Bucket); err != nil {
+		return err
+	}
+	log.Info("Put config %s to bucket %s...", configPath, opts.Bucket)
+	if err := putObject(ctx, c, opts.Bucket, configPath, configContent); err != nil {
+		return err
+	}
+	log.Info("Put jwks %s to bucket %s...", jwksPath, opts.Bucket)
+	return putObject(ctx, c, opts.Bucket, jwksPath, jwksContent)
+}
+
+func createBucket(ctx context.Context, c *gcsClient, name, location string) error {
+	log := logger.NewLogger()
+	if c.project == "" {
+		return fmt.Errorf("bucket %s not found, a project is required to create it", name)
+	}
+	log.Info("bucket %s not found, creating it...", name)
+	bucket := map[string]interface{}{
+		"name": name,
+		// access is only granted through IAM, like S3 buckets with ACLs
+		// disabled
+		"iamConfiguration": map[string]interface{}{
+			"uniformBucketLevelAccess": map[string]interface{}{"enabled": true},
+		},
+	}
+	if location != "" {
+		bucket["location"] = location
+	}
+	_, err := c.doJSON(ctx, http.MethodPost, "/storage/v1/b", url.Values{"project": {c.project}}, bucket, nil)
+	if err != nil {
+		return fmt.Errorf("can't create bucket %s: %v", name, err)
+	}
+	return nil
+}
+
+type iamPolicy struct {
+	Bindings []iamBinding `json:"bindings"`
+	Etag     string       `json:"etag,omitempty"`
+}
+
+type iamBinding struct {
+	Role    string   `json:"role"`
+	Members []string `json:"members"`
+}
+
+// allowAllUsersRead grants allUsers the object viewer role on the bucket,
+// keeping the other bindings.
+func allowAllUsersRead(ctx context.Context, c *gcsClient, bucket string) error {
+	log := logger.NewLogger()
+	path := "/storage/v1/b/" + url.PathEscape(bucket) + "/iam"
+	var policy iamPolicy
+	if _, err := c.doJSON(ctx, http.MethodGet, path, nil, nil, &policy); err != nil {
+		return fmt.Errorf("can't get IAM policy of bucket %s: %v", bucket, err)
+	}
+	for i, b := range policy.Bindings {
+		if b.Role != publicReadRole {
+			continue
+		}
+		for _, m := range b.Members {
+			if m == allUsers {
+				return nil
+			}
+		}
+		policy.Bindings[i].Members = append(b.Members, allUsers)
+		return setPolicy(ctx, c, bucket, path, &policy)
+	}
+	log.Info("Granting %s %s on bucket %s", allUsers, publicReadRole, bucket)
+	policy.Bindings = append(policy.Bindings, iamBinding{Role: publicReadRole, Members: []string{allUsers}})
+	return setPolicy(ctx, c, bucket, path, &policy)
+}
+
+func setPolicy(ctx context.Context, c *gcsClient, bucket, path string, policy *iamPolicy) error {
+	status, err := c.doJSON(ctx, http.MethodPut, path, nil, policy, nil)
+	if status == http.StatusPreconditionFailed {
+		return fmt.Errorf("bucket %s does not allow public access, turn off public access prevention: %v", bucket, err)
+	}
+	if err != nil {
+		return fmt.Errorf("can't set IAM policy of bucket %s: %v", bucket, err)
+	}
+	return nil
+}
+
+// putObject uploads an object with its metadata in a multipart upload, which
+// sets Cache-Control along with the content.
+func putObject(ctx context.Context, c *gcsClient, bucket, name, content string) error {
+	// object names have no leading slash, unlike the paths of the issuer
+	name = strings.TrimPrefix(name, "/")
+	metadata, err := json.Marshal(map[string]string{
+		"name":         name,
+		"contentType":  "application/json",
+		"cacheControl": cacheControl,
+	})
+	if err != nil {
+		return err
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range [][]byte{metadata, []byte(content)} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json"}})
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write(part); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	query := url.Values{"uploadType": {"multipart"}}
+	_, err = c.do(ctx, http.MethodPost, "/upload/storage/v1/b/"+url.PathEscape(bucket)+"/o", query, "multipart/related; boundary="+w.Boundary(), &body, nil)
+	if err != nil {
+		return fmt.Errorf("can't put %s to bucket %s: %v", name, bucket, err)
+	}
+	return nil
+}
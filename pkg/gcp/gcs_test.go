@@ -0,0 +1,196 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeObject struct {
+	content      string
+	contentType  string
+	cacheControl string
+}
+
+// fakeGCS serves the parts of the JSON API UploadToGCS uses, for a single
+// bucket.
+type fakeGCS struct {
+	mu      sync.Mutex
+	bucket  string
+	exists  bool
+	policy  iamPolicy
+	objects map[string]fakeObject
+	// authorization is the header of the last request.
+	authorization string
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.authorization = r.Header.Get("Authorization")
+	bucketPath := "/storage/v1/b/" + f.bucket
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == bucketPath:
+		if !f.exists {
+			http.Error(w, `{"error":{"code":404,"message":"Not Found"}}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": f.bucket})
+	case r.Method == http.MethodPost && r.URL.Path == "/storage/v1/b":
+		f.exists = true
+		json.NewEncoder(w).Encode(map[string]string{"name": f.bucket})
+	case r.Method == http.MethodGet && r.URL.Path == bucketPath+"/iam":
+		json.NewEncoder(w).Encode(f.policy)
+	case r.Method == http.MethodPut && r.URL.Path == bucketPath+"/iam":
+		if err := json.NewDecoder(r.Body).Decode(&f.policy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(f.policy)
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/"+f.bucket+"/o":
+		if err := f.upload(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{}"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeGCS) upload(r *http.Request) error {
+	if t := r.URL.Query().Get("uploadType"); t != "multipart" {
+		return fmt.Errorf("uploadType %s not supported", t)
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	if mediaType != "multipart/related" {
+		return fmt.Errorf("content type %s not supported", mediaType)
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var parts [][]byte
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		b, err := ioutil.ReadAll(p)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, b)
+	}
+	if len(parts) != 2 {
+		return fmt.Errorf("%d parts, want metadata and content", len(parts))
+	}
+	var metadata struct {
+		Name         string `json:"name"`
+		ContentType  string `json:"contentType"`
+		CacheControl string `json:"cacheControl"`
+	}
+	if err := json.Unmarshal(parts[0], &metadata); err != nil {
+		return err
+	}
+	f.objects[metadata.Name] = fakeObject{content: string(parts[1]), contentType: metadata.ContentType, cacheControl: metadata.CacheControl}
+	return nil
+}
+
+func TestUploadToGCS(t *testing.T) {
+	tests := []struct {
+		name    string
+		exists  bool
+		policy  iamPolicy
+		members []string
+	}{
+		{
+			name:    "missing bucket",
+			members: []string{allUsers},
+		},
+		{
+			name:    "bucket with other bindings",
+			exists:  true,
+			policy:  iamPolicy{Bindings: []iamBinding{{Role: publicReadRole, Members: []string{"user:a@example.com"}}}},
+			members: []string{"user:a@example.com", allUsers},
+		},
+		{
+			name:    "public bucket",
+			exists:  true,
+			policy:  iamPolicy{Bindings: []iamBinding{{Role: publicReadRole, Members: []string{allUsers}}}},
+			members: []string{allUsers},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeGCS{bucket: "oidc", exists: tt.exists, policy: tt.policy, objects: map[string]fakeObject{}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			opts := GCSOptions{Bucket: "oidc", Project: "p", Endpoint: srv.URL, Anonymous: true}
+			err := UploadToGCS(context.Background(), opts, "/c1/.well-known/openid-configuration", `{"issuer":"i"}`, "/c1/openid/v1/jwks", `{"keys":[]}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !fake.exists {
+				t.Error("bucket was not created")
+			}
+			if fake.authorization != "" {
+				t.Errorf("anonymous request sent Authorization %q", fake.authorization)
+			}
+			var members []string
+			for _, b := range fake.policy.Bindings {
+				if b.Role == publicReadRole {
+					members = b.Members
+				}
+			}
+			if strings.Join(members, ",") != strings.Join(tt.members, ",") {
+				t.Errorf("members of %s = %v, want %v", publicReadRole, members, tt.members)
+			}
+			want := map[string]string{
+				"c1/.well-known/openid-configuration": `{"issuer":"i"}`,
+				"c1/openid/v1/jwks":                   `{"keys":[]}`,
+			}
+			for name, content := range want {
+				o, ok := fake.objects[name]
+				if !ok {
+					t.Errorf("object %s not uploaded", name)
+					continue
+				}
+				if o.content != content {
+					t.Errorf("content of %s = %q, want %q", name, o.content, content)
+				}
+				if o.contentType != "application/json" {
+					t.Errorf("content type of %s = %q", name, o.contentType)
+				}
+				if o.cacheControl != cacheControl {
+					t.Errorf("cache control of %s = %q, want %q", name, o.cacheControl, cacheControl)
+				}
+			}
+		})
+	}
+}
+
+func TestGCSIssuerURL(t *testing.T) {
+	tests := []struct {
+		endpoint, bucket, prefix string
+		want                     string
+	}{
+		{"", "b", "", "https://storage.googleapis.com/b"},
+		{"", "b", "/c1/", "https://storage.googleapis.com/b/c1"},
+		{"http://localhost:4443/", "b", "c1", "http://localhost:4443/b/c1"},
+	}
+	for _, tt := range tests {
+		if got := GCSIssuerURL(tt.endpoint, tt.bucket, tt.prefix); got != tt.want {
+			t.Errorf("GCSIssuerURL(%q, %q, %q) = %q, want %q", tt.endpoint, tt.bucket, tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -20,7 +21,6 @@ import (
 	apiextensionsv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -32,45 +32,19 @@ var (
 	defaultYamlDelimiter = []byte("---")
 )
 
-func getKubernetesConfigInCluster() (*rest.Config, error) {
-	var config *rest.Config
-	config, err := rest.InClusterConfig()
+// GetKubernetesConfig builds the rest config from kubectl flags such as
+// --kubeconfig, --context and --as. It falls back to the in-cluster config if
+// no kubeconfig is found.
+func GetKubernetesConfig(getter genericclioptions.RESTClientGetter) (*rest.Config, error) {
+	config, err := getter.ToRESTConfig()
 	if err != nil {
-		return getKubernetesLocalConfig()
-	}
-	return config, nil
-}
-
-func getKubernetesLocalConfig() (*rest.Config, error) {
-	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
-	clientCfg := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{})
-	return clientCfg.ClientConfig()
-}
-
-func GetKubernetesConfig(kubePath string) (*rest.Config, error) {
-	var (
-		config *rest.Config
-		err    error
-	)
-
-	// if kubeconfig path is not provided, try to auto detect
-	if kubePath == "" {
-		config, err = getKubernetesConfigInCluster()
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", kubePath)
-		if err != nil {
-			return nil, err
-		}
+		return nil, err
 	}
-
-	return config, err
+	return rest.CopyConfig(config), nil
 }
 
-func GetKubernetesClient(kubePath string) (kubernetes.Interface, error) {
-	config, err := GetKubernetesConfig(kubePath)
+func GetKubernetesClient(getter genericclioptions.RESTClientGetter) (kubernetes.Interface, error) {
+	config, err := GetKubernetesConfig(getter)
 	if err != nil {
 		return nil, err
 	}
@@ -82,9 +56,9 @@ func GetKubernetesClient(kubePath string) (kubernetes.Interface, error) {
 	return client, nil
 }
 
-func Apply(kubePath string, yaml []byte) error {
+func Apply(getter genericclioptions.RESTClientGetter, yaml []byte) error {
 	log := logger.NewLogger()
-	k, err := GetKubernetesClient(kubePath)
+	k, err := GetKubernetesClient(getter)
 	if err != nil {
 		return err
 	}
@@ -113,7 +87,7 @@ func Apply(kubePath string, yaml []byte) error {
 		if err != nil {
 			return err
 		}
-		cli, err := getResourceClient(kubePath, mapping.GroupVersionKind.GroupVersion())
+		cli, err := getResourceClient(getter, mapping.GroupVersionKind.GroupVersion())
 		if err != nil {
 			return err
 		}
@@ -142,8 +116,8 @@ func applyObject(helper *resource.Helper, namespace, name string, obj runtime.Ob
 	return nil
 }
 
-func getResourceClient(kubePath string, gv schema.GroupVersion) (rest.Interface, error) {
-	cfg, err := GetKubernetesConfig(kubePath)
+func getResourceClient(getter genericclioptions.RESTClientGetter, gv schema.GroupVersion) (rest.Interface, error) {
+	cfg, err := GetKubernetesConfig(getter)
 	if err != nil {
 		return nil, err
 	}
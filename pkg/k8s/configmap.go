@@ -0,0 +1,46 @@
+package k8s
+
+import (
+	"context"
+
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	apiv1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// ApplyConfigMap creates a ConfigMap with data, or replaces the data of an
+// existing one.
+func ApplyConfigMap(ctx context.Context, getter genericclioptions.RESTClientGetter, namespace, name string, data map[string]string) error {
+	log := logger.NewLogger()
+	k, err := GetKubernetesClient(getter)
+	if err != nil {
+		return err
+	}
+	client := k.CoreV1().ConfigMaps(namespace)
+	cm, err := client.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &apiv1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"app.kubernetes.io/managed-by": "oidc-config"},
+			},
+			Data: data,
+		}
+		if _, err := client.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return err
+		}
+		log.Info("Created configmap %s/%s", namespace, name)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	cm.Data = data
+	if _, err := client.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+	log.Info("Updated configmap %s/%s", namespace, name)
+	return nil
+}
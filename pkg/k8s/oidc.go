@@ -1,36 +1,81 @@
 package k8s
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"path"
 	"time"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/rest"
 )
 
-func GetURL(url string, token string, ca []byte) ([]byte, error) {
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(ca)
+const maxErrorBodyLength = 512
+
+// HTTPError is returned when the server answers with a non-2xx status.
+type HTTPError struct {
+	URL        string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *HTTPError) Error() string {
+	body := string(e.Body)
+	if len(body) > maxErrorBodyLength {
+		body = body[:maxErrorBodyLength] + "..."
+	}
+	return fmt.Sprintf("GET %s: %d %s: %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode), body)
+}
+
+// GetURL gets a path such as /openid/v1/jwks from the API server, using the
+// same transport as client-go, i.e. with client certificates, exec plugins,
+// proxies and TLS settings from the kubeconfig.
+func GetURL(ctx context.Context, config *rest.Config, urlPath string) ([]byte, error) {
+	client, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+	hasCA := len(config.CAFile) != 0 || len(config.CAData) != 0
+	hasCert := len(config.CertFile) != 0 || len(config.CertData) != 0
+	defaultTLS := hasCA || hasCert || config.Insecure
+	u, _, err := rest.DefaultServerURL(config.Host, "", schema.GroupVersion{}, defaultTLS)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/", u.Path, urlPath)
+	if config.Timeout == 0 {
+		client.Timeout = time.Second * 10
+	}
+	return get(ctx, client, u.String())
+}
 
+// GetPublicURL gets a URL anonymously, the way an OIDC verifier such as STS
+// would.
+func GetPublicURL(ctx context.Context, url string) ([]byte, error) {
 	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs: caCertPool,
-			},
-		},
 		Timeout: time.Second * 10,
 	}
-	req, err := http.NewRequest("GET", url, nil)
+	return get(ctx, client, url)
+}
+
+func get(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("Got error %s", err.Error())
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
 	response, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("Got error %s", err.Error())
 	}
-	data, _ := ioutil.ReadAll(response.Body)
 	defer response.Body.Close()
+	data, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, &HTTPError{URL: url, StatusCode: response.StatusCode, Body: data}
+	}
 	return data, nil
 }
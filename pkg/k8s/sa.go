@@ -2,16 +2,16 @@ package k8s
 
 import (
 	"context"
-	"fmt"
 
 	"github.com/shundezhang/oidc-config/pkg/logger"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 )
 
-func CreateSA(kubePath, saName, saNameSpace, roleArn string) error {
+func CreateSA(getter genericclioptions.RESTClientGetter, saName, saNameSpace, roleArn string) error {
 	log := logger.NewLogger()
-	k, err := GetKubernetesClient(kubePath)
+	k, err := GetKubernetesClient(getter)
 	if err != nil {
 		return err
 	}
@@ -31,7 +31,7 @@ func CreateSA(kubePath, saName, saNameSpace, roleArn string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println(result)
+	log.Info("%v", result)
 	log.Info("Created service account %s/%s", saName, saNameSpace)
 	return nil
 }
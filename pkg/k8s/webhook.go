@@ -0,0 +1,61 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ErrNoCABundle is wrapped by the error of CheckWebhookConfiguration if
+// cert-manager has not injected the CA bundle into a webhook.
+var ErrNoCABundle = errors.New("no CA bundle")
+
+// CheckWebhook returns an error unless the pod identity webhook Deployment
+// has available replicas and its MutatingWebhookConfiguration has a CA bundle.
+func CheckWebhook(ctx context.Context, k kubernetes.Interface, namespace, name string) error {
+	available, _, err := WebhookReplicas(ctx, k, namespace, name)
+	if err != nil {
+		return err
+	}
+	if available == 0 {
+		return fmt.Errorf("deployment %s/%s has no available replicas", namespace, name)
+	}
+	_, err = CheckWebhookConfiguration(ctx, k, name)
+	return err
+}
+
+// WebhookReplicas returns the available and the desired replicas of the pod
+// identity webhook Deployment.
+func WebhookReplicas(ctx context.Context, k kubernetes.Interface, namespace, name string) (available, want int32, err error) {
+	deploy, err := k.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, 0, fmt.Errorf("can't get deployment %s/%s: %v", namespace, name, err)
+	}
+	want = 1
+	if deploy.Spec.Replicas != nil {
+		want = *deploy.Spec.Replicas
+	}
+	return deploy.Status.AvailableReplicas, want, nil
+}
+
+// CheckWebhookConfiguration returns the number of webhooks of the pod identity
+// webhook MutatingWebhookConfiguration, and an error if it has none or one of
+// them has no CA bundle.
+func CheckWebhookConfiguration(ctx context.Context, k kubernetes.Interface, name string) (int, error) {
+	mwc, err := k.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("can't get mutatingwebhookconfiguration %s: %v", name, err)
+	}
+	if len(mwc.Webhooks) == 0 {
+		return 0, fmt.Errorf("mutatingwebhookconfiguration %s has no webhooks", name)
+	}
+	for _, wh := range mwc.Webhooks {
+		if len(wh.ClientConfig.CABundle) == 0 {
+			return 0, fmt.Errorf("webhook %s of mutatingwebhookconfiguration %s has %w", wh.Name, name, ErrNoCABundle)
+		}
+	}
+	return len(mwc.Webhooks), nil
+}
@@ -6,6 +6,8 @@ import (
 	"github.com/fatih/color"
 )
 
+// Logger writes messages to stderr, leaving stdout to the output of commands,
+// such as -o json or yaml.
 type Logger struct {
 }
 
@@ -15,21 +17,21 @@ func NewLogger() *Logger {
 
 func (l *Logger) Info(msg string, args ...interface{}) {
 	if msg == "" {
-		fmt.Println("")
+		fmt.Fprintln(color.Error, "")
 		return
 	}
 
 	c := color.New(color.FgHiCyan)
-	c.Println(fmt.Sprintf(msg, args...))
+	c.Fprintln(color.Error, fmt.Sprintf(msg, args...))
 }
 
 func (l *Logger) Error(err error) {
 	c := color.New(color.FgHiRed)
-	c.Println(fmt.Sprintf("%#v", err))
+	c.Fprintln(color.Error, fmt.Sprintf("%#v", err))
 }
 
 func (l *Logger) Instructions(msg string, args ...interface{}) {
 	white := color.New(color.FgHiWhite)
-	white.Println("")
-	white.Println(fmt.Sprintf(msg, args...))
+	white.Fprintln(color.Error, "")
+	white.Fprintln(color.Error, fmt.Sprintf(msg, args...))
 }
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "oidc_config"
+
+// Stages of a sync that errors are counted by.
+const (
+	StageFetch       = "fetch"
+	StageUpload      = "upload"
+	StageVerify      = "verify"
+	StageCertificate = "certificate"
+	StageThumbprint  = "thumbprint"
+)
+
+var (
+	LastSyncSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_sync_success_timestamp_seconds",
+		Help:      "Time of the last sync that fetched, published and verified the jwks without errors.",
+	})
+	ClusterKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cluster_keys",
+		Help:      "Number of keys in the jwks of the API server.",
+	})
+	PublishedKeys = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "published_keys",
+		Help:      "Number of keys in the jwks at the public issuer URL.",
+	}, []string{"issuer"})
+	SyncErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_errors_total",
+		Help:      "Errors syncing by stage: fetch, upload or verify of the jwks, certificate of the issuer or thumbprint of the OIDC provider.",
+	}, []string{"stage"})
+	ThumbprintAge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "oidc_provider_thumbprint_age_seconds",
+		Help:      "Seconds since the controller last updated the thumbprint of the OIDC provider in IAM, or since the provider was created.",
+	}, []string{"provider"})
+	ThumbprintMatches = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "oidc_provider_thumbprint_matches",
+		Help:      "1 if the thumbprints of the OIDC provider in IAM include the top CA of the certificate chain the issuer serves now, 0 if not.",
+	}, []string{"provider"})
+	CertificateExpiryDays = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "issuer_certificate_expiry_days",
+		Help:      "Days until the certificate served at the issuer URL expires.",
+	}, []string{"issuer"})
+	WebhookAvailable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pod_identity_webhook_available",
+		Help:      "1 if the pod identity webhook has available replicas and a mutating webhook configuration with a CA bundle, 0 if not.",
+	}, []string{"namespace", "name"})
+)
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		LastSyncSuccess, ClusterKeys, PublishedKeys, SyncErrors,
+		ThumbprintAge, ThumbprintMatches, CertificateExpiryDays, WebhookAvailable,
+	)
+	// report every stage from the start, so rate() works on the first error
+	for _, stage := range []string{StageFetch, StageUpload, StageVerify, StageCertificate, StageThumbprint} {
+		SyncErrors.WithLabelValues(stage)
+	}
+}
+
+// Handler serves the metrics for Prometheus to scrape.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
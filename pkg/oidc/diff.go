@@ -0,0 +1,38 @@
+package oidc
+
+import "reflect"
+
+// JWKSDiff is the difference between the key set served by the cluster and
+// a published copy, by kid.
+type JWKSDiff struct {
+	// Added are keys of the cluster that are not published.
+	Added []string `json:"added"`
+	// Removed are published keys the cluster no longer has.
+	Removed []string `json:"removed"`
+	// Changed are keys published with a kid of the cluster but different key
+	// material.
+	Changed []string `json:"changed"`
+}
+
+func (d *JWKSDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func DiffJWKS(cluster, published *JWKS) *JWKSDiff {
+	diff := &JWKSDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for i := range cluster.Keys {
+		k := &cluster.Keys[i]
+		p := published.Key(k.KeyID)
+		if p == nil {
+			diff.Added = append(diff.Added, k.KeyID)
+		} else if !reflect.DeepEqual(k, p) {
+			diff.Changed = append(diff.Changed, k.KeyID)
+		}
+	}
+	for i := range published.Keys {
+		if cluster.Key(published.Keys[i].KeyID) == nil {
+			diff.Removed = append(diff.Removed, published.Keys[i].KeyID)
+		}
+	}
+	return diff
+}
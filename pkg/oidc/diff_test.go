@@ -0,0 +1,64 @@
+package oidc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffJWKS(t *testing.T) {
+	changed := testJWKS("a", "b")
+	changed.Keys[1].N = "other"
+	tests := []struct {
+		name      string
+		cluster   *JWKS
+		published *JWKS
+		added     []string
+		removed   []string
+		changed   []string
+	}{
+		{
+			name:      "same keys in another order",
+			cluster:   testJWKS("a", "b"),
+			published: testJWKS("b", "a"),
+		},
+		{
+			name:      "nothing published",
+			cluster:   testJWKS("a"),
+			published: testJWKS(),
+			added:     []string{"a"},
+		},
+		{
+			name:      "rotated",
+			cluster:   testJWKS("b", "c"),
+			published: testJWKS("a", "b"),
+			added:     []string{"c"},
+			removed:   []string{"a"},
+		},
+		{
+			name:      "different key material",
+			cluster:   testJWKS("a", "b"),
+			published: changed,
+			changed:   []string{"b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := DiffJWKS(tt.cluster, tt.published)
+			for _, c := range []struct {
+				name      string
+				got, want []string
+			}{
+				{"added", diff.Added, tt.added},
+				{"removed", diff.Removed, tt.removed},
+				{"changed", diff.Changed, tt.changed},
+			} {
+				if strings.Join(c.got, ",") != strings.Join(c.want, ",") {
+					t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+				}
+			}
+			if empty := len(tt.added)+len(tt.removed)+len(tt.changed) == 0; diff.Empty() != empty {
+				t.Errorf("Empty() = %v, want %v", diff.Empty(), empty)
+			}
+		})
+	}
+}
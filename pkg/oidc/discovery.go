@@ -0,0 +1,74 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+const (
+	DiscoveryPath = "/.well-known/openid-configuration"
+	JWKSPath      = "/openid/v1/jwks"
+)
+
+// DiscoveryDocument is the content served by the API server at
+// /.well-known/openid-configuration.
+type DiscoveryDocument struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// ParseDiscoveryDocument parses a discovery document without checking it, so
+// that Issuer.Validate can report everything that is wrong with it.
+func ParseDiscoveryDocument(data []byte) (*DiscoveryDocument, error) {
+	doc := &DiscoveryDocument{}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, fmt.Errorf("invalid discovery document: %v", err)
+	}
+	return doc, nil
+}
+
+// Validate checks that the fields required by OpenID Connect Discovery are present.
+func (d *DiscoveryDocument) Validate() error {
+	if d.Issuer == "" {
+		return errors.New("discovery document has no issuer")
+	}
+	if _, err := d.IssuerURL(); err != nil {
+		return err
+	}
+	if d.JWKSURI == "" {
+		return errors.New("discovery document has no jwks_uri")
+	}
+	if _, err := url.Parse(d.JWKSURI); err != nil {
+		return fmt.Errorf("invalid jwks_uri %s: %v", d.JWKSURI, err)
+	}
+	if len(d.ResponseTypesSupported) == 0 {
+		return errors.New("discovery document has no response_types_supported")
+	}
+	if len(d.SubjectTypesSupported) == 0 {
+		return errors.New("discovery document has no subject_types_supported")
+	}
+	if len(d.IDTokenSigningAlgValuesSupported) == 0 {
+		return errors.New("discovery document has no id_token_signing_alg_values_supported")
+	}
+	return nil
+}
+
+func (d *DiscoveryDocument) IssuerURL() (*url.URL, error) {
+	u, err := url.Parse(d.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer %s: %v", d.Issuer, err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid issuer %s: not an absolute URL", d.Issuer)
+	}
+	return u, nil
+}
+
+func (d *DiscoveryDocument) Marshal() ([]byte, error) {
+	return json.Marshal(d)
+}
@@ -0,0 +1,82 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"k8s.io/client-go/rest"
+)
+
+// Issuer holds the discovery document and key set of a cluster, both parsed
+// and as the raw bytes they were served as.
+type Issuer struct {
+	Discovery    *DiscoveryDocument
+	JWKS         *JWKS
+	RawDiscovery []byte
+	RawJWKS      []byte
+}
+
+// Check returns the first problem of the discovery document or key set that
+// keeps them from being published. Validate reports all problems.
+func (i *Issuer) Check() error {
+	if err := i.Discovery.Validate(); err != nil {
+		return err
+	}
+	return i.JWKS.Validate()
+}
+
+// Fetch gets the discovery document and key set from the API server. They are
+// not checked, see Check and Validate.
+func Fetch(ctx context.Context, config *rest.Config) (*Issuer, error) {
+	rawDiscovery, err := k8s.GetURL(ctx, config, DiscoveryPath)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := ParseDiscoveryDocument(rawDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", DiscoveryPath, err)
+	}
+	rawJWKS, err := k8s.GetURL(ctx, config, JWKSPath)
+	if err != nil {
+		return nil, err
+	}
+	jwks, err := ParseJWKS(rawJWKS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", JWKSPath, err)
+	}
+	return &Issuer{
+		Discovery:    discovery,
+		JWKS:         jwks,
+		RawDiscovery: rawDiscovery,
+		RawJWKS:      rawJWKS,
+	}, nil
+}
+
+// FetchPublic gets the discovery document at issuerURL and the key set at its
+// jwks_uri anonymously, the way AWS STS and other verifiers do.
+func FetchPublic(ctx context.Context, issuerURL string) (*Issuer, error) {
+	rawDiscovery, err := k8s.GetPublicURL(ctx, strings.TrimSuffix(issuerURL, "/")+DiscoveryPath)
+	if err != nil {
+		return nil, err
+	}
+	discovery, err := ParseDiscoveryDocument(rawDiscovery)
+	if err != nil {
+		return nil, fmt.Errorf("%s%s: %v", issuerURL, DiscoveryPath, err)
+	}
+	rawJWKS, err := k8s.GetPublicURL(ctx, discovery.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	jwks, err := ParseJWKS(rawJWKS)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", discovery.JWKSURI, err)
+	}
+	return &Issuer{
+		Discovery:    discovery,
+		JWKS:         jwks,
+		RawDiscovery: rawDiscovery,
+		RawJWKS:      rawJWKS,
+	}, nil
+}
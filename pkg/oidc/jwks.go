@@ -0,0 +1,128 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// JSONWebKey is a single public key as served in the API server's JWKS.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JWKS is the key set served by the API server at /openid/v1/jwks.
+type JWKS struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// ParseJWKS parses a key set without checking it, see Validate.
+func ParseJWKS(data []byte) (*JWKS, error) {
+	jwks := &JWKS{}
+	if err := json.Unmarshal(data, jwks); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %v", err)
+	}
+	return jwks, nil
+}
+
+// Validate checks that the key set is not empty and every key has a type.
+func (j *JWKS) Validate() error {
+	if len(j.Keys) == 0 {
+		return errors.New("jwks has no keys")
+	}
+	for i := range j.Keys {
+		if j.Keys[i].KeyType == "" {
+			return fmt.Errorf("jwks key %d has no kty", i)
+		}
+	}
+	return nil
+}
+
+// Key returns the key with the given kid, or nil if there is none.
+func (j *JWKS) Key(kid string) *JSONWebKey {
+	for i := range j.Keys {
+		if j.Keys[i].KeyID == kid {
+			return &j.Keys[i]
+		}
+	}
+	return nil
+}
+
+// PublicKey decodes the RSA or EC key material of the JWK.
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %v", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %v", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %v", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %v", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, errors.New("empty value")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (j *JWKS) KeyIDs() []string {
+	kids := make([]string, 0, len(j.Keys))
+	for i := range j.Keys {
+		kids = append(kids, j.Keys[i].KeyID)
+	}
+	return kids
+}
+
+func (j *JWKS) Marshal() ([]byte, error) {
+	return json.Marshal(j)
+}
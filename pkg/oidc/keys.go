@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/util"
+	"k8s.io/client-go/util/keyutil"
+)
+
+// KeyID computes the kid of a public key the same way kube-apiserver does:
+// the unpadded base64url encoded SHA-256 of its PKIX DER encoding.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(hash[:]), nil
+}
+
+// NewJSONWebKey returns the JWK of an RSA or ECDSA public key as served by
+// kube-apiserver.
+func NewJSONWebKey(pub crypto.PublicKey) (*JSONWebKey, error) {
+	kid, err := KeyID(pub)
+	if err != nil {
+		return nil, err
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			KeyType:   "RSA",
+			Use:       "sig",
+			KeyID:     kid,
+			Algorithm: "RS256",
+			N:         base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		var alg string
+		switch k.Curve.Params().Name {
+		case "P-256":
+			alg = "ES256"
+		case "P-384":
+			alg = "ES384"
+		case "P-521":
+			alg = "ES512"
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve.Params().Name)
+		}
+		return &JSONWebKey{
+			KeyType:   "EC",
+			Use:       "sig",
+			KeyID:     kid,
+			Algorithm: alg,
+			Curve:     k.Curve.Params().Name,
+			X:         base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size))),
+			Y:         base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size))),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// PublicKeysFromFiles reads the public keys in PEM files the same way
+// kube-apiserver reads --service-account-key-file: public keys, certificates
+// and private keys are all accepted.
+func PublicKeysFromFiles(files []string) ([]crypto.PublicKey, error) {
+	keys := []crypto.PublicKey{}
+	for _, file := range files {
+		fileKeys, err := keyutil.PublicKeysFromFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		for _, k := range fileKeys {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// NewJWKS builds the key set kube-apiserver would serve for the public keys.
+func NewJWKS(keys []crypto.PublicKey) (*JWKS, error) {
+	jwks := &JWKS{Keys: []JSONWebKey{}}
+	for _, pub := range keys {
+		jwk, err := NewJSONWebKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		if jwks.Key(jwk.KeyID) != nil {
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, *jwk)
+	}
+	return jwks, nil
+}
+
+// NewIssuer builds the discovery document and key set kube-apiserver would
+// serve with --service-account-issuer=issuerURL and the given keys, without
+// talking to a cluster.
+func NewIssuer(issuerURL string, keys []crypto.PublicKey) (*Issuer, error) {
+	jwks, err := NewJWKS(keys)
+	if err != nil {
+		return nil, err
+	}
+	return NewIssuerFromJWKS(issuerURL, jwks)
+}
+
+// NewIssuerFromJWKS builds the discovery document for a key set.
+func NewIssuerFromJWKS(issuerURL string, jwks *JWKS) (*Issuer, error) {
+	if err := jwks.Validate(); err != nil {
+		return nil, err
+	}
+	discovery := &DiscoveryDocument{
+		Issuer:                           issuerURL,
+		JWKSURI:                          strings.TrimSuffix(issuerURL, "/") + JWKSPath,
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: signingAlgs(jwks),
+	}
+	if err := discovery.Validate(); err != nil {
+		return nil, err
+	}
+	rawDiscovery, err := discovery.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	rawJWKS, err := jwks.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{
+		Discovery:    discovery,
+		JWKS:         jwks,
+		RawDiscovery: rawDiscovery,
+		RawJWKS:      rawJWKS,
+	}, nil
+}
+
+func signingAlgs(jwks *JWKS) []string {
+	algs := []string{}
+	for _, k := range jwks.Keys {
+		if !util.Contains(algs, k.Algorithm) {
+			algs = append(algs, k.Algorithm)
+		}
+	}
+	sort.Strings(algs)
+	return algs
+}
@@ -0,0 +1,81 @@
+package oidc
+
+import (
+	"testing"
+
+	"k8s.io/client-go/util/keyutil"
+)
+
+// The kids were computed the way kube-apiserver does with:
+//
+//	openssl pkey -pubin -outform DER | openssl dgst -sha256 -binary | base64 | tr '+/' '-_' | tr -d '='
+const (
+	rsaPublicKey = `-----BEGIN PUBLIC KEY-----
+MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEAvjVNT25qfvKU27U3T7v2
+met3eKbbKPt5x5K0DVbPANY7rk1pxDGM8RwRbSo1JqMhacyc5Vvj/NugE2H/gT1E
++w5Vl5BzXYREkD2/eEltB8m4EtMzt5PPaJ7KUmp8/jIJCXB9lpHyxsLQ535C/VCf
+92e+50N8+fetbpzHaScIia2PLNVHgpA4GR6eFidgyotYQYbDzj5R7VFtRAVimr5m
+pH2O2aRWM2bf+sobR4VN2kdQgoCMVRXRR/KZ5qBA+Z7VObILCQuXYwaB+eaxK/Wc
+e0dbkzyue2o3Cczp0zjzZKDvnVp7hG7iC945K4ZvcE4wvCypGBcIGLEWrgPYudmC
+PQIDAQAB
+-----END PUBLIC KEY-----
+`
+	rsaKeyID = "aKDS05WJloqNaXHhsfvGGmm3Q_cbZSCYkqrstS3ly8k"
+
+	ecPublicKey = `-----BEGIN PUBLIC KEY-----
+MFkwEwYHKoZIzj0CAQYIKoZIzj0DAQcDQgAEya2DWWo+KIkEBM6E65vK5FrmICkn
+gJXMf6oTgpk100md0RHbRTnCpjlNDrbwJYo76aVN+8UTkKGCN/FLzWz3cQ==
+-----END PUBLIC KEY-----
+`
+	ecKeyID = "Va-a27zWHRYF36OH23Wnm2PZ-_ckoSpuOKbthVGl4Ns"
+)
+
+func TestKeyID(t *testing.T) {
+	tests := []struct {
+		name string
+		pem  string
+		kid  string
+		kty  string
+		alg  string
+	}{
+		{name: "RSA", pem: rsaPublicKey, kid: rsaKeyID, kty: "RSA", alg: "RS256"},
+		{name: "ECDSA P-256", pem: ecPublicKey, kid: ecKeyID, kty: "EC", alg: "ES256"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := keyutil.ParsePublicKeysPEM([]byte(tt.pem))
+			if err != nil {
+				t.Fatal(err)
+			}
+			kid, err := KeyID(keys[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid != tt.kid {
+				t.Errorf("KeyID() = %s, want %s", kid, tt.kid)
+			}
+			jwk, err := NewJSONWebKey(keys[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if jwk.KeyID != tt.kid || jwk.KeyType != tt.kty || jwk.Algorithm != tt.alg || jwk.Use != "sig" {
+				t.Errorf("NewJSONWebKey() = %+v, want kid %s, kty %s, alg %s", jwk, tt.kid, tt.kty, tt.alg)
+			}
+			// the key decoded from the JWK has the same kid, as verifiers
+			// compute it
+			pub, err := jwk.PublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kid, err := KeyID(pub); err != nil || kid != tt.kid {
+				t.Errorf("KeyID() of the decoded JWK = %s, %v, want %s", kid, err, tt.kid)
+			}
+		})
+	}
+}
+
+func TestKeyIDUnsupportedKey(t *testing.T) {
+	if _, err := KeyID("not a key"); err == nil {
+		t.Error("KeyID() of an unsupported key succeeded")
+	}
+}
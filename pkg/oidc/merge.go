@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// MetadataPath is where metadata about published keys is stored next to the
+// config, relative to the issuer path. It is not served to verifiers.
+const MetadataPath = "/.oidc-config/keys.json"
+
+// KeyMetadata records when published keys stopped being served by the
+// cluster.
+type KeyMetadata struct {
+	Retired map[string]time.Time `json:"retired"`
+}
+
+func ParseKeyMetadata(data []byte) (*KeyMetadata, error) {
+	meta := &KeyMetadata{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, meta); err != nil {
+			return nil, fmt.Errorf("invalid key metadata: %v", err)
+		}
+	}
+	if meta.Retired == nil {
+		meta.Retired = map[string]time.Time{}
+	}
+	return meta, nil
+}
+
+func (m *KeyMetadata) Marshal() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// MergeResult is the key set to publish after merging.
+type MergeResult struct {
+	JWKS     *JWKS
+	Metadata *KeyMetadata
+	// Retained are published keys the cluster no longer has that are kept
+	// until their grace period ends.
+	Retained []string
+	// Pruned are retired keys whose grace period has ended.
+	Pruned []string
+}
+
+// MergeJWKS merges the keys of the cluster with the currently published
+// keys, so tokens signed by a key that has just been rotated out still
+// validate. A published key missing from the cluster is retired at now and
+// kept until grace has passed.
+func MergeJWKS(cluster, published *JWKS, meta *KeyMetadata, grace time.Duration, now time.Time) *MergeResult {
+	result := &MergeResult{
+		JWKS:     &JWKS{Keys: append([]JSONWebKey{}, cluster.Keys...)},
+		Metadata: &KeyMetadata{Retired: map[string]time.Time{}},
+	}
+	if published == nil {
+		return result
+	}
+	for _, k := range published.Keys {
+		if cluster.Key(k.KeyID) != nil {
+			continue
+		}
+		retired, ok := meta.Retired[k.KeyID]
+		if !ok {
+			retired = now
+		}
+		if now.Sub(retired) >= grace {
+			result.Pruned = append(result.Pruned, k.KeyID)
+			continue
+		}
+		result.JWKS.Keys = append(result.JWKS.Keys, k)
+		result.Metadata.Retired[k.KeyID] = retired
+		result.Retained = append(result.Retained, k.KeyID)
+	}
+	return result
+}
+
+// WithJWKS returns a copy of the issuer serving a different key set.
+func (i *Issuer) WithJWKS(jwks *JWKS) (*Issuer, error) {
+	raw, err := jwks.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	discovery := *i.Discovery
+	discovery.IDTokenSigningAlgValuesSupported = signingAlgs(jwks)
+	rawDiscovery, err := discovery.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{
+		Discovery:    &discovery,
+		JWKS:         jwks,
+		RawDiscovery: rawDiscovery,
+		RawJWKS:      raw,
+	}, nil
+}
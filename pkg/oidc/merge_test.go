@@ -0,0 +1,104 @@
+package oidc
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testJWKS(kids ...string) *JWKS {
+	jwks := &JWKS{Keys: []JSONWebKey{}}
+	for _, kid := range kids {
+		jwks.Keys = append(jwks.Keys, JSONWebKey{KeyType: "RSA", KeyID: kid, N: "n-" + kid, E: "AQAB"})
+	}
+	return jwks
+}
+
+func TestMergeJWKS(t *testing.T) {
+	now := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	grace := 24 * time.Hour
+	tests := []struct {
+		name      string
+		cluster   *JWKS
+		published *JWKS
+		retired   map[string]time.Time
+		kids      []string
+		retained  []string
+		pruned    []string
+		metadata  map[string]time.Time
+	}{
+		{
+			name:     "nothing published",
+			cluster:  testJWKS("a"),
+			kids:     []string{"a"},
+			metadata: map[string]time.Time{},
+		},
+		{
+			name:      "same keys",
+			cluster:   testJWKS("a", "b"),
+			published: testJWKS("b", "a"),
+			kids:      []string{"a", "b"},
+			metadata:  map[string]time.Time{},
+		},
+		{
+			name:      "key just rotated out is retired now",
+			cluster:   testJWKS("b"),
+			published: testJWKS("a"),
+			kids:      []string{"b", "a"},
+			retained:  []string{"a"},
+			metadata:  map[string]time.Time{"a": now},
+		},
+		{
+			name:      "retired key within grace period is retained",
+			cluster:   testJWKS("b"),
+			published: testJWKS("b", "a"),
+			retired:   map[string]time.Time{"a": now.Add(-grace + time.Second)},
+			kids:      []string{"b", "a"},
+			retained:  []string{"a"},
+			metadata:  map[string]time.Time{"a": now.Add(-grace + time.Second)},
+		},
+		{
+			name:      "retired key past grace period is pruned",
+			cluster:   testJWKS("b"),
+			published: testJWKS("b", "a"),
+			retired:   map[string]time.Time{"a": now.Add(-grace)},
+			kids:      []string{"b"},
+			pruned:    []string{"a"},
+			metadata:  map[string]time.Time{},
+		},
+		{
+			name:      "key back in the cluster is no longer retired",
+			cluster:   testJWKS("a", "b"),
+			published: testJWKS("b", "a"),
+			retired:   map[string]time.Time{"a": now.Add(-time.Hour)},
+			kids:      []string{"a", "b"},
+			metadata:  map[string]time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta := &KeyMetadata{Retired: tt.retired}
+			if meta.Retired == nil {
+				meta.Retired = map[string]time.Time{}
+			}
+			result := MergeJWKS(tt.cluster, tt.published, meta, grace, now)
+			if got := strings.Join(result.JWKS.KeyIDs(), ","); got != strings.Join(tt.kids, ",") {
+				t.Errorf("kids = %s, want %s", got, strings.Join(tt.kids, ","))
+			}
+			if got := strings.Join(result.Retained, ","); got != strings.Join(tt.retained, ",") {
+				t.Errorf("retained = %s, want %s", got, strings.Join(tt.retained, ","))
+			}
+			if got := strings.Join(result.Pruned, ","); got != strings.Join(tt.pruned, ",") {
+				t.Errorf("pruned = %s, want %s", got, strings.Join(tt.pruned, ","))
+			}
+			if len(result.Metadata.Retired) != len(tt.metadata) {
+				t.Errorf("retired = %v, want %v", result.Metadata.Retired, tt.metadata)
+			}
+			for kid, at := range tt.metadata {
+				if !result.Metadata.Retired[kid].Equal(at) {
+					t.Errorf("%s retired at %v, want %v", kid, result.Metadata.Retired[kid], at)
+				}
+			}
+		})
+	}
+}
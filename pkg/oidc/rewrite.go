@@ -0,0 +1,47 @@
+package oidc
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Rewrite returns a copy of the issuer to be published at issuerURL, with
+// issuer and jwks_uri in the discovery document pointing there. The key set
+// is left as is.
+func (i *Issuer) Rewrite(issuerURL string) (*Issuer, error) {
+	u, err := url.Parse(issuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer URL %s: %v", issuerURL, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid issuer URL %s: must be an absolute https URL", issuerURL)
+	}
+
+	// the issuer is kept as is, verifiers compare it with the iss claim of
+	// tokens as a string
+	discovery := *i.Discovery
+	discovery.Issuer = issuerURL
+	discovery.JWKSURI = strings.TrimSuffix(issuerURL, "/") + JWKSPath
+	raw, err := discovery.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &Issuer{
+		Discovery:    &discovery,
+		JWKS:         i.JWKS,
+		RawDiscovery: raw,
+		RawJWKS:      i.RawJWKS,
+	}, nil
+}
+
+// CheckTokenIssuer checks that tokens issued by the cluster, whose iss claim is
+// the issuer in its discovery document, are accepted by verifiers that
+// discover the issuer at issuerURL. Verifiers such as AWS STS compare them
+// exactly, so a trailing slash matters.
+func (i *Issuer) CheckTokenIssuer(issuerURL string) error {
+	if i.Discovery.Issuer != issuerURL {
+		return fmt.Errorf("cluster tokens have iss %s, which does not match issuer URL %s; set --service-account-issuer=%s on the API server first", i.Discovery.Issuer, issuerURL, issuerURL)
+	}
+	return nil
+}
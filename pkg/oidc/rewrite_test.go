@@ -0,0 +1,81 @@
+package oidc
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckTokenIssuer(t *testing.T) {
+	tests := []struct {
+		name      string
+		iss       string
+		issuerURL string
+		ok        bool
+	}{
+		{name: "same", iss: "https://oidc.example.com/cluster1", issuerURL: "https://oidc.example.com/cluster1", ok: true},
+		{name: "same with trailing slash", iss: "https://oidc.example.com/", issuerURL: "https://oidc.example.com/", ok: true},
+		{name: "trailing slash added", iss: "https://oidc.example.com/cluster1", issuerURL: "https://oidc.example.com/cluster1/"},
+		{name: "trailing slash removed", iss: "https://oidc.example.com/", issuerURL: "https://oidc.example.com"},
+		{name: "other path", iss: "https://oidc.example.com/cluster1", issuerURL: "https://oidc.example.com/cluster2"},
+		{name: "other host", iss: "https://kubernetes.default.svc", issuerURL: "https://oidc.example.com"},
+		{name: "other case", iss: "https://oidc.example.com/Cluster1", issuerURL: "https://oidc.example.com/cluster1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer, err := NewIssuerFromJWKS(tt.iss, testJWKS("a"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = issuer.CheckTokenIssuer(tt.issuerURL)
+			if tt.ok && err != nil {
+				t.Errorf("CheckTokenIssuer() = %v, want nil", err)
+			}
+			if !tt.ok {
+				if err == nil {
+					t.Fatal("CheckTokenIssuer() = nil, want an error")
+				}
+				// the error tells which flag to set
+				if want := "--service-account-issuer=" + tt.issuerURL + " "; !strings.Contains(err.Error(), want) {
+					t.Errorf("CheckTokenIssuer() = %v, want it to contain %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	issuer, err := NewIssuerFromJWKS("https://kubernetes.default.svc", testJWKS("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		issuerURL string
+		jwksURI   string
+		invalid   bool
+	}{
+		{issuerURL: "https://oidc.example.com/cluster1", jwksURI: "https://oidc.example.com/cluster1" + JWKSPath},
+		{issuerURL: "https://oidc.example.com/", jwksURI: "https://oidc.example.com" + JWKSPath},
+		{issuerURL: "http://oidc.example.com", invalid: true},
+		{issuerURL: "/cluster1", invalid: true},
+	} {
+		rewritten, err := issuer.Rewrite(tt.issuerURL)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("Rewrite(%s) succeeded, want an error", tt.issuerURL)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d := rewritten.Discovery; d.Issuer != tt.issuerURL || d.JWKSURI != tt.jwksURI {
+			t.Errorf("Rewrite(%s) has issuer %s and jwks_uri %s, want %s", tt.issuerURL, d.Issuer, d.JWKSURI, tt.jwksURI)
+		}
+		if !strings.Contains(string(rewritten.RawDiscovery), tt.jwksURI) {
+			t.Errorf("Rewrite(%s) raw discovery %s does not contain %s", tt.issuerURL, rewritten.RawDiscovery, tt.jwksURI)
+		}
+		if issuer.Discovery.Issuer != "https://kubernetes.default.svc" {
+			t.Errorf("Rewrite(%s) changed the original issuer to %s", tt.issuerURL, issuer.Discovery.Issuer)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"k8s.io/client-go/util/keyutil"
+)
+
+const DefaultKeyBits = 2048
+
+// LoadOrGenerateSigningKey returns the public key of the service account
+// signing key in keyFile. If keyFile does not exist, a new RSA key is
+// generated and written to keyFile, and its public key to pubFile. An existing
+// key is never overwritten, but its public key is written to pubFile if that
+// is missing.
+func LoadOrGenerateSigningKey(keyFile, pubFile string, bits int) (crypto.PublicKey, bool, error) {
+	log := logger.NewLogger()
+	if _, err := os.Stat(keyFile); err == nil {
+		keys, err := keyutil.PublicKeysFromFile(keyFile)
+		if err != nil {
+			return nil, false, fmt.Errorf("%s: %v", keyFile, err)
+		}
+		log.Info("Using existing signing key %s", keyFile)
+		if _, err := os.Stat(pubFile); os.IsNotExist(err) {
+			if err := writePublicKey(pubFile, keys[0]); err != nil {
+				return nil, false, err
+			}
+			log.Info("Wrote missing public key %s of %s", pubFile, keyFile)
+		} else if err != nil {
+			return nil, false, err
+		}
+		return keys[0], false, nil
+	} else if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+	pub, err := GenerateSigningKey(keyFile, pubFile, bits)
+	if err != nil {
+		return nil, false, err
+	}
+	return pub, true, nil
+}
+
+// GenerateSigningKey generates a new RSA signing key and writes it to keyFile,
+// and its public key to pubFile.
+func GenerateSigningKey(keyFile, pubFile string, bits int) (crypto.PublicKey, error) {
+	log := logger.NewLogger()
+	if bits < minRSAKeyBits {
+		return nil, fmt.Errorf("key size %d is too small, at least %d is required", bits, minRSAKeyBits)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := writePublicKey(pubFile, &key.PublicKey); err != nil {
+		return nil, err
+	}
+	log.Info("Generated signing key %s and public key %s", keyFile, pubFile)
+	return &key.PublicKey, nil
+}
+
+// writePublicKey writes a public key to file in PEM.
+func writePublicKey(file string, pub crypto.PublicKey) error {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644)
+}
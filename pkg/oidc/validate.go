@@ -0,0 +1,132 @@
+package oidc
+
+import (
+	"crypto/rsa"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/shundezhang/oidc-config/pkg/util"
+)
+
+const (
+	minRSAKeyBits = 2048
+	requiredAlg   = "RS256"
+)
+
+// CheckResult is the outcome of a single compliance check.
+type CheckResult struct {
+	Name    string `json:"name"`
+	Passed  bool   `json:"passed"`
+	Message string `json:"message"`
+}
+
+// Report is the list of compliance checks run against an issuer.
+type Report []CheckResult
+
+func (r Report) Passed() bool {
+	for i := range r {
+		if !r[i].Passed {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(name string, passed bool, msg string, args ...interface{}) {
+	*r = append(*r, CheckResult{Name: name, Passed: passed, Message: fmt.Sprintf(msg, args...)})
+}
+
+// Validate checks the issuer against OpenID Connect Discovery and the
+// requirements of AWS IAM OIDC providers. servedFrom is the URL the discovery
+// document will be published under; if empty, the issuer itself is assumed.
+func (i *Issuer) Validate(servedFrom string) Report {
+	report := Report{}
+	d := i.Discovery
+
+	issuer, err := url.Parse(d.Issuer)
+	if err != nil {
+		report.add("issuer", false, "invalid issuer %s: %v", d.Issuer, err)
+	} else if issuer.Scheme != "https" {
+		report.add("issuer", false, "issuer %s is not https", d.Issuer)
+	} else if issuer.RawQuery != "" || issuer.Fragment != "" {
+		report.add("issuer", false, "issuer %s must not have a query or fragment", d.Issuer)
+	} else {
+		report.add("issuer", true, "issuer %s is https", d.Issuer)
+	}
+
+	if servedFrom == "" {
+		servedFrom = d.Issuer
+	}
+	// compared exactly, as verifiers compare the iss claim of tokens
+	if d.Issuer != servedFrom {
+		report.add("issuer-location", false, "issuer %s does not match %s the document is served from", d.Issuer, servedFrom)
+	} else {
+		report.add("issuer-location", true, "discovery document served from %s%s", strings.TrimSuffix(servedFrom, "/"), DiscoveryPath)
+	}
+
+	jwksURI, err := url.Parse(d.JWKSURI)
+	if err != nil {
+		report.add("jwks_uri", false, "invalid jwks_uri %s: %v", d.JWKSURI, err)
+	} else if jwksURI.Scheme != "https" {
+		report.add("jwks_uri", false, "jwks_uri %s is not https", d.JWKSURI)
+	} else if !strings.HasPrefix(d.JWKSURI, strings.TrimSuffix(d.Issuer, "/")+"/") {
+		report.add("jwks_uri", false, "jwks_uri %s is not under issuer %s", d.JWKSURI, d.Issuer)
+	} else {
+		report.add("jwks_uri", true, "jwks_uri %s is under the issuer", d.JWKSURI)
+	}
+
+	if util.Contains(d.IDTokenSigningAlgValuesSupported, requiredAlg) {
+		report.add("signing-alg", true, "id_token_signing_alg_values_supported contains %s", requiredAlg)
+	} else {
+		report.add("signing-alg", false, "id_token_signing_alg_values_supported %v does not contain %s", d.IDTokenSigningAlgValuesSupported, requiredAlg)
+	}
+
+	if len(d.SubjectTypesSupported) > 0 {
+		report.add("subject-types", true, "subject_types_supported is %v", d.SubjectTypesSupported)
+	} else {
+		report.add("subject-types", false, "subject_types_supported is missing")
+	}
+
+	if util.Contains(d.ResponseTypesSupported, "id_token") {
+		report.add("response-types", true, "response_types_supported contains id_token")
+	} else {
+		report.add("response-types", false, "response_types_supported %v does not contain id_token", d.ResponseTypesSupported)
+	}
+
+	if len(i.JWKS.Keys) == 0 {
+		report.add("jwks", false, "jwks has no keys")
+	}
+	for n := range i.JWKS.Keys {
+		report.addKey(n, &i.JWKS.Keys[n])
+	}
+	return report
+}
+
+func (r *Report) addKey(n int, k *JSONWebKey) {
+	name := fmt.Sprintf("jwks-key-%d", n)
+	missing := []string{}
+	if k.KeyID == "" {
+		missing = append(missing, "kid")
+	}
+	if k.Algorithm == "" {
+		missing = append(missing, "alg")
+	}
+	if k.Use == "" {
+		missing = append(missing, "use")
+	}
+	if len(missing) > 0 {
+		r.add(name, false, "key %s has no %s", k.KeyID, strings.Join(missing, ", "))
+		return
+	}
+	pub, err := k.PublicKey()
+	if err != nil {
+		r.add(name, false, "key %s: %v", k.KeyID, err)
+		return
+	}
+	if rsaKey, ok := pub.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSAKeyBits {
+		r.add(name, false, "key %s has a %d bit modulus, at least %d is required", k.KeyID, rsaKey.N.BitLen(), minRSAKeyBits)
+		return
+	}
+	r.add(name, true, "key %s (%s, %s) is valid", k.KeyID, k.KeyType, k.Algorithm)
+}
@@ -0,0 +1,67 @@
+package publish
+
+import (
+	"context"
+
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	ConfigMap = "configmap"
+
+	configMapNameFlag      = "configmap-name"
+	configMapNamespaceFlag = "configmap-namespace"
+
+	// ConfigMapDiscoveryKey and ConfigMapJWKSKey are the keys the config and
+	// jwks are stored under.
+	ConfigMapDiscoveryKey = "openid-configuration"
+	ConfigMapJWKSKey      = "jwks"
+)
+
+// configMapPublisher stores the config and jwks in a ConfigMap of the
+// cluster, for a web server in the cluster to serve.
+type configMapPublisher struct {
+	getter    genericclioptions.RESTClientGetter
+	namespace string
+	name      string
+}
+
+func NewConfigMap(getter genericclioptions.RESTClientGetter, namespace, name string) Publisher {
+	return &configMapPublisher{getter: getter, namespace: namespace, name: name}
+}
+
+func init() {
+	Register(Factory{
+		Name:  ConfigMap,
+		Usage: "store config and jwks in a ConfigMap of the cluster",
+		AddFlags: func(fs *pflag.FlagSet) {
+			fs.String(configMapNameFlag, "oidc-discovery", "Name of the ConfigMap to store config and jwks in")
+			fs.String(configMapNamespaceFlag, "default", "Namespace of the ConfigMap")
+		},
+		New: func(fs *pflag.FlagSet, c Config) (Publisher, error) {
+			name, err := fs.GetString(configMapNameFlag)
+			if err != nil {
+				return nil, err
+			}
+			namespace, err := fs.GetString(configMapNamespaceFlag)
+			if err != nil {
+				return nil, err
+			}
+			return NewConfigMap(c.Getter, namespace, name), nil
+		},
+	})
+}
+
+func (p *configMapPublisher) Publish(ctx context.Context, issuer *oidc.Issuer) (*oidc.Issuer, error) {
+	err := k8s.ApplyConfigMap(ctx, p.getter, p.namespace, p.name, map[string]string{
+		ConfigMapDiscoveryKey: string(issuer.RawDiscovery),
+		ConfigMapJWKSKey:      string(issuer.RawJWKS),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
@@ -0,0 +1,82 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/shundezhang/oidc-config/pkg/site"
+	"github.com/spf13/pflag"
+)
+
+const (
+	Dir = "dir"
+
+	OutDirFlag          = "out-dir"
+	webServerFlag       = "web-server"
+	webServerConfigFlag = "web-server-config"
+)
+
+// dirPublisher writes the config and jwks to a directory for a static web
+// server.
+type dirPublisher struct {
+	dir       string
+	webServer string
+	// webServerConfig is the file the web server config is written to,
+	// next to dir if empty.
+	webServerConfig string
+}
+
+func NewDir(dir, webServer, webServerConfig string) Publisher {
+	return &dirPublisher{dir: dir, webServer: webServer, webServerConfig: webServerConfig}
+}
+
+func init() {
+	Register(Factory{
+		Name:  Dir,
+		Usage: "write config and jwks to --" + OutDirFlag + " for a static web server",
+		AddFlags: func(fs *pflag.FlagSet) {
+			fs.String(OutDirFlag, "", "Write config and jwks under this directory for a static web server")
+			fs.String(webServerFlag, "", "With --"+OutDirFlag+", also write a config snippet for this web server: nginx or caddy")
+			fs.String(webServerConfigFlag, "", "File to write the --"+webServerFlag+" config snippet to, outside --"+OutDirFlag+"; defaults to <out-dir>.nginx.conf or <out-dir>.Caddyfile")
+		},
+		New: func(fs *pflag.FlagSet, c Config) (Publisher, error) {
+			dir, err := fs.GetString(OutDirFlag)
+			if err != nil {
+				return nil, err
+			}
+			if dir == "" {
+				return nil, fmt.Errorf("publisher %s requires --%s", Dir, OutDirFlag)
+			}
+			webServer, err := fs.GetString(webServerFlag)
+			if err != nil {
+				return nil, err
+			}
+			webServerConfig, err := fs.GetString(webServerConfigFlag)
+			if err != nil {
+				return nil, err
+			}
+			return NewDir(dir, webServer, webServerConfig), nil
+		},
+	})
+}
+
+func (p *dirPublisher) Publish(ctx context.Context, issuer *oidc.Issuer) (*oidc.Issuer, error) {
+	log := logger.NewLogger()
+	u, err := issuer.Discovery.IssuerURL()
+	if err != nil {
+		return nil, err
+	}
+	if err := site.Write(p.dir, u.Path, issuer.RawDiscovery, issuer.RawJWKS); err != nil {
+		return nil, err
+	}
+	if p.webServer != "" {
+		file, err := site.WriteServerConfig(p.dir, p.webServerConfig, p.webServer, issuer.Discovery.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		log.Info("Wrote %s config to %s", p.webServer, file)
+	}
+	return issuer, nil
+}
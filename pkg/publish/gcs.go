@@ -0,0 +1,92 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shundezhang/oidc-config/pkg/gcp"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/spf13/pflag"
+)
+
+const (
+	GCS = "gcs"
+
+	gcsBucketFlag      = "gcs-bucket"
+	gcsPrefixFlag      = "gcs-prefix"
+	gcsProjectFlag     = "gcs-project"
+	gcsLocationFlag    = "gcs-location"
+	gcsEndpointFlag    = "gcs-endpoint"
+	gcsCredentialsFlag = "gcs-credentials"
+	gcsAnonymousFlag   = "gcs-anonymous"
+)
+
+// GCSPublisher uploads the config and jwks to a Google Cloud Storage bucket.
+// The bucket decides the issuer URL, so clusters on GCP can publish an issuer
+// for workloads that assume AWS roles.
+type GCSPublisher struct {
+	Options gcp.GCSOptions
+	Prefix  string
+}
+
+func init() {
+	Register(Factory{
+		Name:  GCS,
+		Usage: "upload config and jwks to Google Cloud Storage",
+		AddFlags: func(fs *pflag.FlagSet) {
+			fs.String(gcsBucketFlag, "", "GCS bucket to publish to, created if missing; the issuer URL is https://storage.googleapis.com/<bucket>/<prefix>")
+			fs.String(gcsPrefixFlag, "", "Path in the GCS bucket and of the issuer URL")
+			fs.String(gcsProjectFlag, "", "Google Cloud project to create the GCS bucket in, defaults to the project of the credentials")
+			fs.String(gcsLocationFlag, "", "Location to create the GCS bucket in, e.g. US or europe-west1")
+			fs.String(gcsEndpointFlag, "", "URL of the storage API, e.g. a Private Service Connect endpoint or a fake GCS server for testing")
+			fs.String(gcsCredentialsFlag, "", "Service account key file, defaults to the application default credentials")
+			fs.Bool(gcsAnonymousFlag, false, "Send requests to the storage API without credentials, e.g. to a fake GCS server")
+		},
+		New: func(fs *pflag.FlagSet, c Config) (Publisher, error) {
+			p := &GCSPublisher{}
+			var err error
+			if p.Options.Bucket, err = fs.GetString(gcsBucketFlag); err != nil {
+				return nil, err
+			}
+			if p.Prefix, err = fs.GetString(gcsPrefixFlag); err != nil {
+				return nil, err
+			}
+			if p.Options.Project, err = fs.GetString(gcsProjectFlag); err != nil {
+				return nil, err
+			}
+			if p.Options.Location, err = fs.GetString(gcsLocationFlag); err != nil {
+				return nil, err
+			}
+			if p.Options.Endpoint, err = fs.GetString(gcsEndpointFlag); err != nil {
+				return nil, err
+			}
+			if p.Options.CredentialsFile, err = fs.GetString(gcsCredentialsFlag); err != nil {
+				return nil, err
+			}
+			if p.Options.Anonymous, err = fs.GetBool(gcsAnonymousFlag); err != nil {
+				return nil, err
+			}
+			if p.Options.Bucket == "" {
+				return nil, fmt.Errorf("publisher %s requires --%s", GCS, gcsBucketFlag)
+			}
+			return p, nil
+		},
+	})
+}
+
+func (p *GCSPublisher) Target() string {
+	return gcp.GCSIssuerURL(p.Options.Endpoint, p.Options.Bucket, p.Prefix)
+}
+
+func (p *GCSPublisher) Publish(ctx context.Context, issuer *oidc.Issuer) (*oidc.Issuer, error) {
+	issuer, err := targetIssuer(issuer, gcp.GCSIssuerURL(p.Options.Endpoint, p.Options.Bucket, p.Prefix), "")
+	if err != nil {
+		return nil, err
+	}
+	prefix := IssuerPrefix(p.Prefix)
+	err = gcp.UploadToGCS(ctx, p.Options, prefix+oidc.DiscoveryPath, string(issuer.RawDiscovery), prefix+oidc.JWKSPath, string(issuer.RawJWKS))
+	if err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
@@ -0,0 +1,218 @@
+package publish
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+// HistoryPath is where a copy of every published config and jwks is kept next
+// to them, one directory per entry. It is not public.
+const HistoryPath = "/.oidc-config/history"
+
+const (
+	historyManifest = "manifest.json"
+	// historyIDFormat sorts in the order entries were published.
+	historyIDFormat = "20060102T150405.000Z"
+)
+
+// HistoryEntry describes config and jwks as published at one point in time.
+type HistoryEntry struct {
+	ID     string    `json:"id" yaml:"id"`
+	Time   time.Time `json:"time" yaml:"time"`
+	Issuer string    `json:"issuer" yaml:"issuer"`
+	KeyIDs []string  `json:"kids" yaml:"kids"`
+	// ClusterHash is the sha256 of the jwks served by the cluster, before
+	// retired keys were merged in, which tells which key set of the cluster
+	// was published.
+	ClusterHash string `json:"clusterHash" yaml:"clusterHash"`
+	// JWKSHash is the sha256 of the jwks as published.
+	JWKSHash string `json:"jwksHash" yaml:"jwksHash"`
+	// ConfigVersion and JWKSVersion are the versions of the published
+	// objects in a versioned bucket. The entry has no copies of them then.
+	ConfigVersion string `json:"configVersion,omitempty" yaml:"configVersion,omitempty"`
+	JWKSVersion   string `json:"jwksVersion,omitempty" yaml:"jwksVersion,omitempty"`
+	// PublishMode and DistributionARN are how the entry was made public, and
+	// how a rollback makes it public again.
+	PublishMode     string `json:"publishMode,omitempty" yaml:"publishMode,omitempty"`
+	DistributionARN string `json:"distributionARN,omitempty" yaml:"distributionARN,omitempty"`
+	// RollbackOf is the entry that was published again by a rollback.
+	RollbackOf string `json:"rollbackOf,omitempty" yaml:"rollbackOf,omitempty"`
+}
+
+// Versioned reports whether the entry refers to object versions instead of
+// keeping copies.
+func (e *HistoryEntry) Versioned() bool {
+	return e.ConfigVersion != "" && e.JWKSVersion != ""
+}
+
+// Hash returns the sha256 of a document in hex.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func historyDir(prefix, id string) string {
+	return prefix + HistoryPath + "/" + id + "/"
+}
+
+// RecordHistory records the issuer just uploaded to the bucket under prefix.
+// Without object versions in upload, copies of config and jwks are kept with
+// the manifest.
+func RecordHistory(opts aws.S3Options, prefix string, issuer *oidc.Issuer, clusterHash string, upload *aws.Upload, rollbackOf string) (*HistoryEntry, error) {
+	now := time.Now().UTC()
+	entry := &HistoryEntry{
+		ID:          now.Format(historyIDFormat),
+		Time:        now,
+		Issuer:      issuer.Discovery.Issuer,
+		KeyIDs:      issuer.JWKS.KeyIDs(),
+		ClusterHash: clusterHash,
+		JWKSHash:    Hash(issuer.RawJWKS),
+		PublishMode: opts.Publish,
+		RollbackOf:  rollbackOf,
+	}
+	if entry.PublishMode == "" {
+		entry.PublishMode = aws.PublishACL
+	}
+	if entry.PublishMode == aws.PublishCloudFront {
+		entry.DistributionARN = opts.DistributionARN
+	}
+	if upload != nil {
+		entry.ConfigVersion, entry.JWKSVersion = upload.ConfigVersion, upload.JWKSVersion
+	}
+	dir := historyDir(prefix, entry.ID)
+	if !entry.Versioned() {
+		entry.ConfigVersion, entry.JWKSVersion = "", ""
+		if err := aws.PutPrivateObject(opts, dir+path.Base(oidc.DiscoveryPath), string(issuer.RawDiscovery)); err != nil {
+			return nil, err
+		}
+		if err := aws.PutPrivateObject(opts, dir+path.Base(oidc.JWKSPath), string(issuer.RawJWKS)); err != nil {
+			return nil, err
+		}
+	}
+	b, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// the manifest goes last, so an entry is listed once it is complete
+	if err := aws.PutPrivateObject(opts, dir+historyManifest, string(b)); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ListHistory returns the entries recorded in the bucket under prefix, the
+// latest first.
+func ListHistory(opts aws.S3Options, prefix string) ([]*HistoryEntry, error) {
+	log := logger.NewLogger()
+	ids, err := aws.ListS3Prefixes(opts, prefix+HistoryPath+"/")
+	if err != nil {
+		return nil, err
+	}
+	entries := []*HistoryEntry{}
+	for _, id := range ids {
+		entry, err := GetHistory(opts, prefix, id)
+		if err != nil {
+			log.Info("Skipping history entry %s: %v", id, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+	return entries, nil
+}
+
+// GetHistory returns the entry with the given id.
+func GetHistory(opts aws.S3Options, prefix, id string) (*HistoryEntry, error) {
+	raw, err := aws.DownloadFromS3(opts, historyDir(prefix, id)+historyManifest)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, fmt.Errorf("history entry %s not found in bucket %s", id, opts.Bucket)
+	}
+	entry := &HistoryEntry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil, fmt.Errorf("can't parse manifest of history entry %s: %v", id, err)
+	}
+	return entry, nil
+}
+
+// historyIssuer returns the config and jwks of an entry, checking the jwks
+// against the hash in its manifest.
+func historyIssuer(opts aws.S3Options, prefix string, entry *HistoryEntry) (*oidc.Issuer, error) {
+	dir := historyDir(prefix, entry.ID)
+	var config, jwks []byte
+	var err error
+	if entry.Versioned() {
+		if config, err = aws.DownloadVersionFromS3(opts, prefix+oidc.DiscoveryPath, entry.ConfigVersion); err != nil {
+			return nil, err
+		}
+		if jwks, err = aws.DownloadVersionFromS3(opts, prefix+oidc.JWKSPath, entry.JWKSVersion); err != nil {
+			return nil, err
+		}
+	} else {
+		if config, err = aws.DownloadFromS3(opts, dir+path.Base(oidc.DiscoveryPath)); err != nil {
+			return nil, err
+		}
+		if jwks, err = aws.DownloadFromS3(opts, dir+path.Base(oidc.JWKSPath)); err != nil {
+			return nil, err
+		}
+	}
+	if config == nil || jwks == nil {
+		return nil, fmt.Errorf("config or jwks of history entry %s is no longer in bucket %s", entry.ID, opts.Bucket)
+	}
+	if Hash(jwks) != entry.JWKSHash {
+		return nil, fmt.Errorf("jwks of history entry %s does not match the hash in its manifest", entry.ID)
+	}
+	issuer := &oidc.Issuer{RawDiscovery: config, RawJWKS: jwks}
+	if issuer.Discovery, err = oidc.ParseDiscoveryDocument(config); err != nil {
+		return nil, err
+	}
+	if issuer.JWKS, err = oidc.ParseJWKS(jwks); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+// Rollback publishes the config and jwks of a history entry again, made
+// public the way the entry was, and records that as a new entry. With
+// aws.PublishCloudFront the cached copies in the distribution are
+// invalidated.
+func Rollback(opts aws.S3Options, prefix, id string) (*HistoryEntry, error) {
+	entry, err := GetHistory(opts, prefix, id)
+	if err != nil {
+		return nil, err
+	}
+	issuer, err := historyIssuer(opts, prefix, entry)
+	if err != nil {
+		return nil, err
+	}
+	if entry.PublishMode != "" {
+		opts.Publish, opts.DistributionARN = entry.PublishMode, entry.DistributionARN
+	}
+	if opts.Publish == aws.PublishCloudFront && opts.DistributionARN == "" {
+		return nil, fmt.Errorf("history entry %s was published through CloudFront but does not record the distribution", id)
+	}
+	paths := []string{prefix + oidc.DiscoveryPath, prefix + oidc.JWKSPath}
+	upload, err := aws.RestoreToS3(opts, paths[0], string(issuer.RawDiscovery), paths[1], string(issuer.RawJWKS))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Publish == aws.PublishCloudFront {
+		if err := aws.InvalidateDistribution(opts.Profile, opts.DistributionARN, paths); err != nil {
+			return nil, err
+		}
+	}
+	return RecordHistory(opts, prefix, issuer, entry.ClusterHash, upload, entry.ID)
+}
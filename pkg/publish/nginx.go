@@ -0,0 +1,334 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const (
+	Nginx = "nginx"
+
+	nginxNameFlag         = "nginx-name"
+	nginxNamespaceFlag    = "nginx-namespace"
+	nginxImageFlag        = "nginx-image"
+	nginxCertIssuerFlag   = "nginx-cert-issuer"
+	nginxCertIssuerKind   = "nginx-cert-issuer-kind"
+	nginxIngressFlag      = "nginx-ingress"
+	nginxIngressClassFlag = "nginx-ingress-class"
+
+	// nginxConfKey is the key of the nginx server config in the ConfigMap.
+	nginxConfKey = "default.conf"
+)
+
+// NginxOptions selects where and how the config is served in the cluster.
+type NginxOptions struct {
+	Namespace string
+	// Name is the name of the ConfigMap, Deployment, Service, Ingress and
+	// Certificate, and of the Secret with the certificate.
+	Name  string
+	Image string
+	// CertIssuer and CertIssuerKind are the cert-manager issuer of the
+	// certificate.
+	CertIssuer     string
+	CertIssuerKind string
+	// Ingress exposes the issuer host through an Ingress, which terminates
+	// TLS with the same certificate.
+	Ingress      bool
+	IngressClass string
+}
+
+// nginxPublisher stores the config and jwks in a ConfigMap, and serves it
+// from an nginx Deployment with a certificate from cert-manager, so verifiers
+// in a private network don't need a public bucket.
+type nginxPublisher struct {
+	getter genericclioptions.RESTClientGetter
+	opts   NginxOptions
+}
+
+func NewNginx(getter genericclioptions.RESTClientGetter, opts NginxOptions) Publisher {
+	return &nginxPublisher{getter: getter, opts: opts}
+}
+
+func init() {
+	Register(Factory{
+		Name:  Nginx,
+		Usage: "serve config and jwks from a ConfigMap with nginx in the cluster",
+		AddFlags: func(fs *pflag.FlagSet) {
+			fs.String(nginxNameFlag, "oidc-discovery", "Name of the ConfigMap, Deployment, Service and Ingress serving config and jwks in the cluster")
+			fs.String(nginxNamespaceFlag, "default", "Namespace to serve config and jwks in")
+			fs.String(nginxImageFlag, "nginx:stable-alpine", "nginx image serving config and jwks")
+			fs.String(nginxCertIssuerFlag, "", "cert-manager issuer of the TLS certificate for the issuer host")
+			fs.String(nginxCertIssuerKind, "ClusterIssuer", "Kind of --"+nginxCertIssuerFlag+": ClusterIssuer or Issuer")
+			fs.Bool(nginxIngressFlag, false, "Also create an Ingress for the issuer host, terminating TLS with the same certificate")
+			fs.String(nginxIngressClassFlag, "", "Ingress class of the Ingress, the default class if not set")
+		},
+		New: func(fs *pflag.FlagSet, c Config) (Publisher, error) {
+			var opts NginxOptions
+			var err error
+			if opts.Name, err = fs.GetString(nginxNameFlag); err != nil {
+				return nil, err
+			}
+			if opts.Namespace, err = fs.GetString(nginxNamespaceFlag); err != nil {
+				return nil, err
+			}
+			if opts.Image, err = fs.GetString(nginxImageFlag); err != nil {
+				return nil, err
+			}
+			if opts.CertIssuer, err = fs.GetString(nginxCertIssuerFlag); err != nil {
+				return nil, err
+			}
+			if opts.CertIssuerKind, err = fs.GetString(nginxCertIssuerKind); err != nil {
+				return nil, err
+			}
+			if opts.Ingress, err = fs.GetBool(nginxIngressFlag); err != nil {
+				return nil, err
+			}
+			if opts.IngressClass, err = fs.GetString(nginxIngressClassFlag); err != nil {
+				return nil, err
+			}
+			if opts.CertIssuer == "" {
+				return nil, fmt.Errorf("publisher %s requires --%s", Nginx, nginxCertIssuerFlag)
+			}
+			return NewNginx(c.Getter, opts), nil
+		},
+	})
+}
+
+var nginxConfTemplate = `# Serve OIDC discovery documents for {{ .Issuer }}
+server {
+    listen 8443 ssl;
+    listen 8080;
+    ssl_certificate /etc/nginx/tls/tls.crt;
+    ssl_certificate_key /etc/nginx/tls/tls.key;
+    root /usr/share/nginx/html;
+
+    location = {{ .Path }}/.well-known/openid-configuration {
+        default_type application/json;
+        add_header Cache-Control "public, max-age=300";
+    }
+    location = {{ .Path }}/openid/v1/jwks {
+        default_type application/json;
+        add_header Cache-Control "public, max-age=300";
+    }
+    location / {
+        return 404;
+    }
+}
+`
+
+// nginxTemplate are the objects serving the ConfigMap. The documents are
+// mounted without subPath, so updates of the ConfigMap are served without a
+// restart.
+var nginxTemplate = `apiVersion: cert-manager.io/v1
+kind: Certificate
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: {{ .Name }}
+    app.kubernetes.io/managed-by: oidc-config
+spec:
+  secretName: {{ .Name }}-tls
+  dnsNames:
+  - {{ .Host }}
+  - {{ .Name }}.{{ .Namespace }}.svc
+  - {{ .Name }}.{{ .Namespace }}.svc.cluster.local
+  issuerRef:
+    name: {{ .CertIssuer }}
+    kind: {{ .CertIssuerKind }}
+    group: cert-manager.io
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: {{ .Name }}
+    app.kubernetes.io/managed-by: oidc-config
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app.kubernetes.io/name: {{ .Name }}
+  template:
+    metadata:
+      labels:
+        app.kubernetes.io/name: {{ .Name }}
+    spec:
+      containers:
+      - name: nginx
+        image: {{ .Image }}
+        ports:
+        - name: https
+          containerPort: 8443
+        - name: http
+          containerPort: 8080
+        readinessProbe:
+          httpGet:
+            path: {{ .Path }}/.well-known/openid-configuration
+            port: http
+        volumeMounts:
+        - name: documents
+          mountPath: /usr/share/nginx/html
+          readOnly: true
+        - name: conf
+          mountPath: /etc/nginx/conf.d
+          readOnly: true
+        - name: tls
+          mountPath: /etc/nginx/tls
+          readOnly: true
+      volumes:
+      - name: documents
+        configMap:
+          name: {{ .Name }}
+          items:
+          - key: {{ .DiscoveryKey }}
+            path: {{ .DiscoveryFile }}
+          - key: {{ .JWKSKey }}
+            path: {{ .JWKSFile }}
+      - name: conf
+        configMap:
+          name: {{ .Name }}
+          items:
+          - key: {{ .ConfKey }}
+            path: {{ .ConfKey }}
+      - name: tls
+        secret:
+          secretName: {{ .Name }}-tls
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: {{ .Name }}
+    app.kubernetes.io/managed-by: oidc-config
+spec:
+  selector:
+    app.kubernetes.io/name: {{ .Name }}
+  ports:
+  - name: https
+    port: 443
+    targetPort: https
+  - name: http
+    port: 80
+    targetPort: http
+{{- if .Ingress }}
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: {{ .Name }}
+  namespace: {{ .Namespace }}
+  labels:
+    app.kubernetes.io/name: {{ .Name }}
+    app.kubernetes.io/managed-by: oidc-config
+spec:
+{{- if .IngressClass }}
+  ingressClassName: {{ .IngressClass }}
+{{- end }}
+  tls:
+  - hosts:
+    - {{ .Host }}
+    secretName: {{ .Name }}-tls
+  rules:
+  - host: {{ .Host }}
+    http:
+      paths:
+      - path: {{ .Path }}/.well-known/openid-configuration
+        pathType: Exact
+        backend:
+          service:
+            name: {{ .Name }}
+            port:
+              name: http
+      - path: {{ .Path }}/openid/v1/jwks
+        pathType: Exact
+        backend:
+          service:
+            name: {{ .Name }}
+            port:
+              name: http
+{{- end }}
+`
+
+type nginxValues struct {
+	NginxOptions
+	Issuer        string
+	Host          string
+	Path          string
+	DiscoveryKey  string
+	DiscoveryFile string
+	JWKSKey       string
+	JWKSFile      string
+	ConfKey       string
+}
+
+func (p *nginxPublisher) Publish(ctx context.Context, issuer *oidc.Issuer) (*oidc.Issuer, error) {
+	log := logger.NewLogger()
+	u, err := issuer.Discovery.IssuerURL()
+	if err != nil {
+		return nil, err
+	}
+	path := strings.TrimSuffix(u.Path, "/")
+	values := nginxValues{
+		NginxOptions: p.opts,
+		Issuer:       issuer.Discovery.Issuer,
+		Host:         u.Hostname(),
+		Path:         path,
+		DiscoveryKey: ConfigMapDiscoveryKey,
+		JWKSKey:      ConfigMapJWKSKey,
+		ConfKey:      nginxConfKey,
+		// paths in a ConfigMap volume are relative
+		DiscoveryFile: strings.TrimPrefix(path+oidc.DiscoveryPath, "/"),
+		JWKSFile:      strings.TrimPrefix(path+oidc.JWKSPath, "/"),
+	}
+	conf, err := render(nginxConfTemplate, values)
+	if err != nil {
+		return nil, err
+	}
+	err = k8s.ApplyConfigMap(ctx, p.getter, p.opts.Namespace, p.opts.Name, map[string]string{
+		ConfigMapDiscoveryKey: string(issuer.RawDiscovery),
+		ConfigMapJWKSKey:      string(issuer.RawJWKS),
+		nginxConfKey:          string(conf),
+	})
+	if err != nil {
+		return nil, err
+	}
+	objects, err := render(nginxTemplate, values)
+	if err != nil {
+		return nil, err
+	}
+	if err := k8s.Apply(p.getter, objects); err != nil {
+		return nil, err
+	}
+	target := fmt.Sprintf("service %s/%s", p.opts.Namespace, p.opts.Name)
+	if p.opts.Ingress {
+		target = fmt.Sprintf("ingress %s/%s", p.opts.Namespace, p.opts.Name)
+	}
+	log.Instructions("%s is served by %s. Point %s at it in DNS of the network the verifiers are in.", issuer.Discovery.Issuer, target, values.Host)
+	return issuer, nil
+}
+
+func render(text string, values interface{}) ([]byte, error) {
+	t, err := template.New("").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, values); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,104 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/shundezhang/oidc-config/pkg/apiserver"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/spf13/pflag"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// Publisher makes the config and jwks of an issuer available to verifiers.
+type Publisher interface {
+	// Publish publishes the issuer and returns it as published, which can
+	// differ from the given one, e.g. when published keys are merged in or
+	// the publisher decides the issuer URL.
+	Publish(ctx context.Context, issuer *oidc.Issuer) (*oidc.Issuer, error)
+}
+
+// Targeter is implemented by publishers that decide the issuer URL, such as
+// a GCS bucket, instead of publishing at the issuer URL they are given.
+type Targeter interface {
+	// Target returns the issuer URL the publisher will publish at, or empty
+	// if it is only known once published.
+	Target() string
+}
+
+// targetIssuer returns the issuer to publish at issuerURL, which a Targeter
+// decides. If tokens of the cluster have another iss, verifiers would reject
+// them, so it fails and prints the API server flags to set, followed by hint.
+func targetIssuer(issuer *oidc.Issuer, issuerURL, hint string) (*oidc.Issuer, error) {
+	if err := issuer.CheckTokenIssuer(issuerURL); err != nil {
+		flags := &apiserver.Options{Issuer: issuerURL}
+		msg := fmt.Sprintf("The issuer URL is %s. Start kube-apiserver with:\n\n  --service-account-issuer=%s \\\n  --service-account-jwks-uri=%s",
+			issuerURL, issuerURL, flags.JWKSURI())
+		if hint != "" {
+			msg += "\n\n" + hint
+		}
+		logger.NewLogger().Instructions("%s", msg)
+		return nil, err
+	}
+	// jwks_uri may still point at the API server
+	return issuer.Rewrite(issuerURL)
+}
+
+// Config is what publishers share with the command running them.
+type Config struct {
+	// Profile is the AWS profile.
+	Profile string
+	// Getter selects the cluster the config was read from.
+	Getter genericclioptions.RESTClientGetter
+}
+
+// Factory creates a publisher from its flags. A backend registers a Factory
+// in an init function, and get adds its flags and offers it in --publisher.
+type Factory struct {
+	Name  string
+	Usage string
+	// AddFlags adds the flags of the publisher. Flag names should start with
+	// the publisher name unless they are shared with other commands.
+	AddFlags func(fs *pflag.FlagSet)
+	New      func(fs *pflag.FlagSet, c Config) (Publisher, error)
+}
+
+var factories = map[string]Factory{}
+
+// Register makes a publisher available by name.
+func Register(f Factory) {
+	if _, ok := factories[f.Name]; ok {
+		panic(fmt.Sprintf("publisher %s registered twice", f.Name))
+	}
+	factories[f.Name] = f
+}
+
+// Lookup returns the factory of a publisher.
+func Lookup(name string) (Factory, error) {
+	f, ok := factories[name]
+	if !ok {
+		return Factory{}, fmt.Errorf("publisher %s not supported, use one of %v", name, Names())
+	}
+	return f, nil
+}
+
+// Names returns the names of all publishers, sorted.
+func Names() []string {
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddFlags adds the flags of all publishers.
+func AddFlags(fs *pflag.FlagSet) {
+	for _, name := range Names() {
+		if f := factories[name]; f.AddFlags != nil {
+			f.AddFlags(fs)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package publish
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"github.com/spf13/pflag"
+)
+
+const (
+	S3 = "s3"
+
+	PublishModeFlag = "publish-mode"
+	s3EndpointFlag  = "s3-endpoint"
+	s3PathStyleFlag = "s3-path-style"
+	s3ProfileFlag   = "s3-profile"
+	s3CABundleFlag  = "s3-ca-bundle"
+
+	bucketFlag         = "bucket"
+	regionFlag         = "region"
+	prefixFlag         = "prefix"
+	domainFlag         = "domain"
+	certificateARNFlag = "certificate-arn"
+	MergeKeysFlag      = "merge-keys"
+	keyGracePeriodFlag = "key-grace-period"
+)
+
+// S3Publisher uploads the config and jwks to the S3 bucket the issuer URL
+// points at, or with aws.PublishCloudFront to a private bucket behind a
+// CloudFront distribution.
+type S3Publisher struct {
+	// Options are how to publish, the bucket is filled in from the issuer URL.
+	Options aws.S3Options
+	// CloudFront and Prefix are where to publish with aws.PublishCloudFront,
+	// which decides the issuer URL.
+	CloudFront aws.CloudFrontOptions
+	Prefix     string
+	// MergeKeys keeps published keys the cluster no longer has for
+	// GracePeriod.
+	MergeKeys   bool
+	GracePeriod time.Duration
+}
+
+func init() {
+	Register(Factory{
+		Name:  S3,
+		Usage: "upload config and jwks to S3",
+		AddFlags: func(fs *pflag.FlagSet) {
+			AddS3Flags(fs)
+			fs.String(bucketFlag, "", "With --publish-mode cloudfront, the private S3 bucket to publish to, created if missing")
+			fs.String(regionFlag, "", "With --publish-mode cloudfront, AWS region of the bucket")
+			fs.String(prefixFlag, "", "With --publish-mode cloudfront, path in the bucket and of the issuer URL")
+			fs.String(domainFlag, "", "With --publish-mode cloudfront, custom domain of the distribution; the issuer URL is on the CloudFront domain if not set")
+			fs.String(certificateARNFlag, "", "ACM certificate in us-east-1 for --domain")
+			fs.Bool(MergeKeysFlag, false, "When publishing to S3, keep published keys the cluster no longer has for --key-grace-period, so tokens signed before a key rotation still validate")
+			fs.Duration(keyGracePeriodFlag, 48*time.Hour, "How long --merge-keys keeps a retired key published")
+		},
+		New: newS3FromFlags,
+	})
+}
+
+// AddS3Flags adds the flags selecting how config is published to S3.
+func AddS3Flags(fs *pflag.FlagSet) {
+	fs.String(PublishModeFlag, aws.PublishACL, fmt.Sprintf("How objects in S3 are made public: %s grants public-read on each object; %s is for buckets with ACLs disabled, it allows anonymous reads of the config over TLS in the bucket policy and turns off the Block Public Access settings that prevent it; %s keeps the bucket private and serves it through a CloudFront distribution",
+		aws.PublishACL, aws.PublishBucketPolicy, aws.PublishCloudFront))
+	fs.String(s3EndpointFlag, "", "URL of an S3 compatible store such as MinIO or Ceph RGW to publish to instead of AWS S3")
+	fs.Bool(s3PathStyleFlag, false, "Put the bucket in the path instead of the hostname, which most S3 compatible stores need")
+	fs.String(s3ProfileFlag, "", "Credentials profile to access the bucket with, defaults to --aws-profile")
+	fs.String(s3CABundleFlag, "", "PEM file of CAs to trust for --s3-endpoint in addition to the system ones")
+}
+
+// S3OptionsFromFlags returns the S3 options given by the flags of AddS3Flags,
+// without the bucket.
+func S3OptionsFromFlags(fs *pflag.FlagSet, profile string) (aws.S3Options, error) {
+	opts := aws.S3Options{Profile: profile}
+	var err error
+	if opts.Publish, err = fs.GetString(PublishModeFlag); err != nil {
+		return opts, err
+	}
+	if opts.Endpoint, err = fs.GetString(s3EndpointFlag); err != nil {
+		return opts, err
+	}
+	if opts.PathStyle, err = fs.GetBool(s3PathStyleFlag); err != nil {
+		return opts, err
+	}
+	if opts.CredentialsProfile, err = fs.GetString(s3ProfileFlag); err != nil {
+		return opts, err
+	}
+	if opts.CABundle, err = fs.GetString(s3CABundleFlag); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func newS3FromFlags(fs *pflag.FlagSet, c Config) (Publisher, error) {
+	opts, err := S3OptionsFromFlags(fs, c.Profile)
+	if err != nil {
+		return nil, err
+	}
+	p := &S3Publisher{Options: opts}
+	if p.MergeKeys, err = fs.GetBool(MergeKeysFlag); err != nil {
+		return nil, err
+	}
+	if p.GracePeriod, err = fs.GetDuration(keyGracePeriodFlag); err != nil {
+		return nil, err
+	}
+	if opts.Publish != aws.PublishCloudFront {
+		return p, nil
+	}
+	p.CloudFront.Profile = c.Profile
+	if p.CloudFront.Bucket, err = fs.GetString(bucketFlag); err != nil {
+		return nil, err
+	}
+	if p.CloudFront.Region, err = fs.GetString(regionFlag); err != nil {
+		return nil, err
+	}
+	if p.CloudFront.Domain, err = fs.GetString(domainFlag); err != nil {
+		return nil, err
+	}
+	if p.CloudFront.CertificateARN, err = fs.GetString(certificateARNFlag); err != nil {
+		return nil, err
+	}
+	if p.Prefix, err = fs.GetString(prefixFlag); err != nil {
+		return nil, err
+	}
+	if p.CloudFront.Bucket == "" || p.CloudFront.Region == "" {
+		return nil, fmt.Errorf("--%s %s requires --%s and --%s", PublishModeFlag, aws.PublishCloudFront, bucketFlag, regionFlag)
+	}
+	return p, nil
+}
+
+// Target returns the issuer URL with aws.PublishCloudFront and a custom
+// domain. The domain of a new distribution is only known once it is created.
+func (p *S3Publisher) Target() string {
+	if p.Options.Publish != aws.PublishCloudFront || p.CloudFront.Domain == "" {
+		return ""
+	}
+	return "https://" + p.CloudFront.Domain + IssuerPrefix(p.Prefix)
+}
+
+func (p *S3Publisher) Publish(ctx context.Context, issuer *oidc.Issuer) (*oidc.Issuer, error) {
+	var opts aws.S3Options
+	var prefix string
+	var dist *aws.Distribution
+	var err error
+	if p.Options.Publish == aws.PublishCloudFront {
+		if p.CloudFront.Bucket == "" || p.CloudFront.Region == "" {
+			return nil, fmt.Errorf("publish mode %s requires a bucket and region", aws.PublishCloudFront)
+		}
+		dist, issuer, err = p.cloudFrontIssuer(issuer)
+		if err != nil {
+			return nil, err
+		}
+		prefix = IssuerPrefix(p.Prefix)
+		opts = p.Options
+		opts.Region, opts.Bucket = p.CloudFront.Region, p.CloudFront.Bucket
+		opts.DistributionARN = dist.ARN
+	} else {
+		u, err := issuer.Discovery.IssuerURL()
+		if err != nil {
+			return nil, err
+		}
+		opts, prefix, err = S3Location(p.Options, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+	clusterHash := Hash(issuer.RawJWKS)
+	var meta *oidc.KeyMetadata
+	if p.MergeKeys {
+		issuer, meta, err = mergePublishedKeys(opts, prefix, issuer, p.GracePeriod)
+		if err != nil {
+			return nil, err
+		}
+	}
+	upload, err := aws.UploadToS3(opts, prefix+oidc.DiscoveryPath, string(issuer.RawDiscovery), prefix+oidc.JWKSPath, string(issuer.RawJWKS))
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		b, err := meta.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		if err := aws.PutPrivateObject(opts, prefix+oidc.MetadataPath, string(b)); err != nil {
+			return nil, err
+		}
+	}
+	if dist != nil {
+		// the edges would serve the cached copies until they expire
+		if err := aws.InvalidateDistribution(p.CloudFront.Profile, dist.ARN, []string{prefix + oidc.DiscoveryPath, prefix + oidc.JWKSPath}); err != nil {
+			return nil, err
+		}
+	}
+	// the issuer is published at this point, history is only a convenience
+	if _, err := RecordHistory(opts, prefix, issuer, clusterHash, upload, ""); err != nil {
+		logger.NewLogger().Info("Published, but can't record history: %v", err)
+	}
+	if dist != nil {
+		// the issuer URL only works, and has a certificate to take the
+		// thumbprint of, once the distribution is deployed
+		if err := aws.WaitForDistribution(p.CloudFront.Profile, dist.ID); err != nil {
+			return nil, err
+		}
+	}
+	return issuer, nil
+}
+
+// S3Location fills in the bucket an issuer URL points at and returns the path
+// of the issuer in it.
+func S3Location(opts aws.S3Options, u *url.URL) (aws.S3Options, string, error) {
+	var loc *aws.S3Location
+	var err error
+	if opts.Endpoint != "" {
+		loc, err = aws.ParseS3EndpointURL(u, opts.Endpoint)
+	} else {
+		loc, err = aws.ParseS3URL(u)
+	}
+	if err != nil {
+		return opts, "", err
+	}
+	opts.Bucket, opts.Region = loc.Bucket, loc.Region
+	return opts, loc.Prefix, nil
+}
+
+// cloudFrontIssuer creates or reuses the distribution in front of the bucket
+// and returns the issuer rewritten to its URL.
+func (p *S3Publisher) cloudFrontIssuer(issuer *oidc.Issuer) (*aws.Distribution, *oidc.Issuer, error) {
+	log := logger.NewLogger()
+	dist, err := aws.EnsureDistribution(p.CloudFront)
+	if err != nil {
+		return nil, nil, err
+	}
+	host := dist.DomainName
+	if p.CloudFront.Domain != "" {
+		host = p.CloudFront.Domain
+	}
+	var hint string
+	if p.CloudFront.Domain != "" {
+		hint = fmt.Sprintf("Point %s at %s with a CNAME or alias record.", p.CloudFront.Domain, dist.DomainName)
+	}
+	issuer, err = targetIssuer(issuer, "https://"+host+IssuerPrefix(p.Prefix), hint)
+	if err != nil {
+		return nil, nil, err
+	}
+	if hint != "" {
+		log.Instructions("%s", hint)
+	}
+	return dist, issuer, nil
+}
+
+// IssuerPrefix returns a path in a bucket with a leading but no trailing
+// slash, or empty for the bucket root.
+func IssuerPrefix(prefix string) string {
+	if p := strings.Trim(prefix, "/"); p != "" {
+		return "/" + p
+	}
+	return ""
+}
+
+// mergePublishedKeys adds the keys published in the bucket that the cluster
+// no longer has to the issuer, until their grace period ends.
+func mergePublishedKeys(opts aws.S3Options, prefix string, issuer *oidc.Issuer, grace time.Duration) (*oidc.Issuer, *oidc.KeyMetadata, error) {
+	log := logger.NewLogger()
+	var published *oidc.JWKS
+	raw, err := aws.DownloadFromS3(opts, prefix+oidc.JWKSPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if raw != nil {
+		published, err = oidc.ParseJWKS(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	raw, err = aws.DownloadFromS3(opts, prefix+oidc.MetadataPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := oidc.ParseKeyMetadata(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := oidc.MergeJWKS(issuer.JWKS, published, meta, grace, time.Now())
+	for _, kid := range result.Retained {
+		log.Info("Keeping retired key %s until %s", kid, result.Metadata.Retired[kid].Add(grace).Format(time.RFC3339))
+	}
+	for _, kid := range result.Pruned {
+		log.Info("Removing key %s, its grace period has ended", kid)
+	}
+	merged, err := issuer.WithJWKS(result.JWKS)
+	if err != nil {
+		return nil, nil, err
+	}
+	return merged, result.Metadata, nil
+}
@@ -0,0 +1,265 @@
+package publish
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/aws"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+func TestIssuerPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"cluster1", "/cluster1"},
+		{"/cluster1/", "/cluster1"},
+		{"a/b", "/a/b"},
+		{"//a/b//", "/a/b"},
+	}
+	for _, tt := range tests {
+		if got := IssuerPrefix(tt.prefix); got != tt.want {
+			t.Errorf("IssuerPrefix(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+type fakeS3Object struct {
+	content string
+	acl     string
+}
+
+// fakeS3 serves the parts of the S3 API the publisher uses in path style,
+// like MinIO, without versioning.
+type fakeS3 struct {
+	mu      sync.Mutex
+	buckets map[string]map[string]fakeS3Object
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string
+	Message string
+}
+
+func (f *fakeS3) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: code})
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket, key := parts[0], ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	switch {
+	case bucket == "" && r.Method == http.MethodGet:
+		type b struct{ Name string }
+		var result struct {
+			XMLName xml.Name `xml:"ListAllMyBucketsResult"`
+			Buckets []b      `xml:"Buckets>Bucket"`
+		}
+		for name := range f.buckets {
+			result.Buckets = append(result.Buckets, b{name})
+		}
+		xml.NewEncoder(w).Encode(result)
+	case key == "" && r.Method == http.MethodPut:
+		f.buckets[bucket] = map[string]fakeS3Object{}
+	case f.buckets[bucket] == nil:
+		f.error(w, http.StatusNotFound, "NoSuchBucket")
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, bucket, r.URL.Query().Get("prefix"), r.URL.Query().Get("delimiter"))
+	case r.Method == http.MethodPut:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			f.error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.buckets[bucket][key] = fakeS3Object{content: string(b), acl: r.Header.Get("X-Amz-Acl")}
+	case r.Method == http.MethodGet:
+		o, ok := f.buckets[bucket][key]
+		if !ok {
+			f.error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write([]byte(o.content))
+	default:
+		f.error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, bucket, prefix, delimiter string) {
+	type p struct{ Prefix string }
+	var result struct {
+		XMLName        xml.Name `xml:"ListBucketResult"`
+		Name           string
+		Prefix         string
+		IsTruncated    bool
+		CommonPrefixes []p
+	}
+	result.Name, result.Prefix = bucket, prefix
+	seen := map[string]bool{}
+	for key := range f.buckets[bucket] {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if i := strings.Index(key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			seen[key[:len(prefix)+i+1]] = true
+		}
+	}
+	for cp := range seen {
+		result.CommonPrefixes = append(result.CommonPrefixes, p{cp})
+	}
+	sort.Slice(result.CommonPrefixes, func(i, j int) bool {
+		return result.CommonPrefixes[i].Prefix < result.CommonPrefixes[j].Prefix
+	})
+	xml.NewEncoder(w).Encode(result)
+}
+
+func newTestKey(t *testing.T) crypto.PublicKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &key.PublicKey
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestS3PublisherOnS3CompatibleStore(t *testing.T) {
+	fake := &fakeS3{buckets: map[string]map[string]fakeS3Object{}}
+	srv := httptest.NewTLSServer(fake)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caBundle := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// static credentials, no profiles or instance metadata of the machine
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+
+	p := &S3Publisher{
+		Options:     aws.S3Options{Endpoint: srv.URL, PathStyle: true, CABundle: caBundle},
+		MergeKeys:   true,
+		GracePeriod: time.Hour,
+	}
+	issuerURL := srv.URL + "/oidc/cluster1"
+	oldKey, newKey := newTestKey(t), newTestKey(t)
+
+	first, err := oidc.NewIssuer(issuerURL, []crypto.PublicKey{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Publish(context.Background(), first); err != nil {
+		t.Fatal(err)
+	}
+	objects := fake.buckets["oidc"]
+	for key, want := range map[string][]byte{
+		"cluster1" + oidc.DiscoveryPath: first.RawDiscovery,
+		"cluster1" + oidc.JWKSPath:      first.RawJWKS,
+	} {
+		o, ok := objects[key]
+		if !ok {
+			t.Fatalf("%s not published, objects: %v", key, objects)
+		}
+		if o.content != string(want) {
+			t.Errorf("content of %s = %s, want %s", key, o.content, want)
+		}
+		if o.acl != "public-read" {
+			t.Errorf("ACL of %s = %q, want public-read", key, o.acl)
+		}
+	}
+
+	// after a key rotation the old key stays published for the grace period;
+	// history ids have millisecond resolution
+	time.Sleep(2 * time.Millisecond)
+	rotated, err := oidc.NewIssuer(issuerURL, []crypto.PublicKey{newKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := p.Publish(context.Background(), rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(rotated.JWKS.KeyIDs(), first.JWKS.KeyIDs()...)
+	if got := merged.JWKS.KeyIDs(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("kids after rotation = %v, want %v", got, want)
+	}
+	if objects["cluster1"+oidc.JWKSPath].content != string(merged.RawJWKS) {
+		t.Error("merged jwks not published")
+	}
+	meta, err := oidc.ParseKeyMetadata([]byte(objects["cluster1"+oidc.MetadataPath].content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta.Retired[first.JWKS.KeyIDs()[0]]; !ok || len(meta.Retired) != 1 {
+		t.Errorf("retired keys = %v, want %v", meta.Retired, first.JWKS.KeyIDs())
+	}
+	if o := objects["cluster1"+oidc.MetadataPath]; o.acl != "" {
+		t.Errorf("metadata is public: ACL %q", o.acl)
+	}
+
+	// both publishes are in the history, and a rollback publishes the first
+	// jwks again
+	opts, prefix, err := S3Location(p.Options, mustParseURL(t, issuerURL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ListHistory(opts, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d history entries, want 2", len(entries))
+	}
+	oldest := entries[1]
+	if oldest.JWKSHash != Hash(first.RawJWKS) || oldest.ClusterHash != Hash(first.RawJWKS) || oldest.PublishMode != aws.PublishACL {
+		t.Errorf("oldest history entry = %+v", oldest)
+	}
+	if entries[0].ClusterHash != Hash(rotated.RawJWKS) || entries[0].JWKSHash != Hash(merged.RawJWKS) {
+		t.Errorf("latest history entry = %+v", entries[0])
+	}
+	time.Sleep(2 * time.Millisecond)
+	entry, err := Rollback(opts, prefix, oldest.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.RollbackOf != oldest.ID {
+		t.Errorf("rollback entry = %+v, want rollback of %s", entry, oldest.ID)
+	}
+	if o := objects["cluster1"+oidc.JWKSPath]; o.content != string(first.RawJWKS) || o.acl != "public-read" {
+		t.Errorf("jwks after rollback = %s with ACL %q, want %s", o.content, o.acl, first.RawJWKS)
+	}
+}
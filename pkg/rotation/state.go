@@ -0,0 +1,85 @@
+package rotation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+// Phase is how far a signing key rotation has got. Every phase is recorded in
+// the state file once it is done, so an interrupted rotation can be resumed.
+type Phase string
+
+const (
+	// Started means nothing has been done yet.
+	Started Phase = ""
+	// Generated means the new keypair has been written.
+	Generated Phase = "generated"
+	// Published means a jwks with the old and new keys has been uploaded.
+	Published Phase = "published"
+	// Propagated means the public issuer serves the old and new keys, and
+	// the API server can be switched to the new signing key.
+	Propagated Phase = "propagated"
+	// Switched means the API server signs with the new key. The old key stays
+	// published until the wait period has passed.
+	Switched Phase = "switched"
+	// Finished means the old key has been removed from the published jwks.
+	Finished Phase = "finished"
+)
+
+// State is the progress of a rotation, saved as JSON.
+type State struct {
+	Phase     Phase  `json:"phase"`
+	IssuerURL string `json:"issuerURL"`
+	// OldKeys are the keys the cluster served when the rotation started.
+	OldKeys *oidc.JWKS `json:"oldKeys"`
+	// NewKey is the public key of the new signing key.
+	NewKey        *oidc.JSONWebKey `json:"newKey,omitempty"`
+	NewKeyFile    string           `json:"newKeyFile,omitempty"`
+	NewPublicFile string           `json:"newPublicKeyFile,omitempty"`
+	PublishedAt   time.Time        `json:"publishedAt,omitempty"`
+	PropagatedAt  time.Time        `json:"propagatedAt,omitempty"`
+	SwitchedAt    time.Time        `json:"switchedAt,omitempty"`
+	FinishedAt    time.Time        `json:"finishedAt,omitempty"`
+}
+
+// Load reads the state file, or returns an empty state if it does not exist.
+func Load(file string) (*State, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return &State{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	s := &State{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", file, err)
+	}
+	return s, nil
+}
+
+// Save writes the state file, replacing it atomically.
+func (s *State) Save(file string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// JWKS returns the keys to publish while both old and new keys are valid.
+func (s *State) JWKS() *oidc.JWKS {
+	jwks := &oidc.JWKS{Keys: append([]oidc.JSONWebKey{}, s.OldKeys.Keys...)}
+	if s.NewKey != nil && jwks.Key(s.NewKey.KeyID) == nil {
+		jwks.Keys = append(jwks.Keys, *s.NewKey)
+	}
+	return jwks
+}
@@ -0,0 +1,89 @@
+package rotation
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+func testKey(kid string) oidc.JSONWebKey {
+	return oidc.JSONWebKey{KeyType: "RSA", KeyID: kid, N: "n-" + kid, E: "AQAB"}
+}
+
+func TestLoadSave(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rotation.json")
+	state, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Phase != Started {
+		t.Fatalf("phase of a missing state file = %q, want %q", state.Phase, Started)
+	}
+
+	// every phase is saved and loaded again, so the rotation resumes there
+	newKey := testKey("new")
+	state.OldKeys = &oidc.JWKS{Keys: []oidc.JSONWebKey{testKey("old")}}
+	state.NewKey = &newKey
+	for _, phase := range []Phase{Generated, Published, Propagated, Switched, Finished} {
+		state.Phase = phase
+		state.SwitchedAt = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		if err := state.Save(file); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := Load(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.Phase != phase || !loaded.SwitchedAt.Equal(state.SwitchedAt) || loaded.NewKey.KeyID != "new" {
+			t.Errorf("loaded state = %+v, want %+v", loaded, state)
+		}
+	}
+	if matches, _ := filepath.Glob(file + ".tmp"); len(matches) > 0 {
+		t.Errorf("temporary file %v left behind", matches)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rotation.json")
+	if err := ioutil.WriteFile(file, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil || !strings.Contains(err.Error(), file) {
+		t.Errorf("Load() of an invalid file = %v, want an error naming it", err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	tests := []struct {
+		name   string
+		old    []string
+		newKey string
+		want   []string
+	}{
+		{name: "not generated", old: []string{"a"}, want: []string{"a"}},
+		{name: "new key", old: []string{"a", "b"}, newKey: "c", want: []string{"a", "b", "c"}},
+		{name: "new key already served", old: []string{"a", "c"}, newKey: "c", want: []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &State{OldKeys: &oidc.JWKS{}}
+			for _, kid := range tt.old {
+				state.OldKeys.Keys = append(state.OldKeys.Keys, testKey(kid))
+			}
+			if tt.newKey != "" {
+				k := testKey(tt.newKey)
+				state.NewKey = &k
+			}
+			if got := state.JWKS().KeyIDs(); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("JWKS() = %v, want %v", got, tt.want)
+			}
+			if len(state.OldKeys.Keys) != len(tt.old) {
+				t.Errorf("JWKS() changed the old keys to %v", state.OldKeys.KeyIDs())
+			}
+		})
+	}
+}
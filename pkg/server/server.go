@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shundezhang/oidc-config/pkg/k8s"
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+// Source is a cluster whose config and jwks are served.
+type Source struct {
+	// Name identifies the source in logs, e.g. the kubeconfig context.
+	Name   string
+	Getter genericclioptions.RESTClientGetter
+	// IssuerURL rewrites the issuer of the cluster if set. The documents are
+	// served at the path of the issuer URL.
+	IssuerURL string
+}
+
+// document is a served file with its ETag.
+type document struct {
+	content  []byte
+	etag     string
+	modified time.Time
+}
+
+func newDocument(content []byte, previous *document) *document {
+	// keep Last-Modified if the content did not change
+	if previous != nil && bytes.Equal(previous.content, content) {
+		return previous
+	}
+	sum := sha256.Sum256(content)
+	return &document{
+		content:  content,
+		etag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+		modified: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+// DefaultRefreshTimeout is how long a source may take to refresh by default.
+const DefaultRefreshTimeout = 30 * time.Second
+
+// Server serves the config and jwks of several clusters, each at the path of
+// its issuer URL, refreshed from the API servers.
+type Server struct {
+	Sources []Source
+	// MaxAge is how long verifiers may cache the documents.
+	MaxAge time.Duration
+	// RefreshTimeout bounds the refresh of each source, so that a cluster
+	// that does not answer does not hold up the others. DefaultRefreshTimeout
+	// if zero.
+	RefreshTimeout time.Duration
+
+	mu   sync.RWMutex
+	docs map[string]*document
+	// paths are the paths each source is served at, to drop the old ones
+	// when its issuer changes.
+	paths map[string][]string
+}
+
+// Refresh fetches the config and jwks of a source. On failure the documents
+// served before are kept.
+func (s *Server) Refresh(ctx context.Context, src Source) error {
+	c, err := k8s.GetKubernetesConfig(src.Getter)
+	if err != nil {
+		return err
+	}
+	issuer, err := oidc.Fetch(ctx, c)
+	if err != nil {
+		return err
+	}
+	return s.update(src, issuer)
+}
+
+// update serves the config and jwks fetched from a source, at its issuer URL
+// if set.
+func (s *Server) update(src Source, issuer *oidc.Issuer) error {
+	var err error
+	if src.IssuerURL != "" {
+		// documents for another issuer than the iss of the tokens of the
+		// cluster would not validate them
+		if err := issuer.CheckTokenIssuer(src.IssuerURL); err != nil {
+			return fmt.Errorf("%s: %v", src.Name, err)
+		}
+		if issuer, err = issuer.Rewrite(src.IssuerURL); err != nil {
+			return err
+		}
+	}
+	u, err := issuer.Discovery.IssuerURL()
+	if err != nil {
+		return err
+	}
+	prefix := strings.TrimSuffix(u.Path, "/")
+	files := map[string][]byte{
+		prefix + oidc.DiscoveryPath: issuer.RawDiscovery,
+		prefix + oidc.JWKSPath:      issuer.RawJWKS,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.docs == nil {
+		s.docs = map[string]*document{}
+		s.paths = map[string][]string{}
+	}
+	for name, paths := range s.paths {
+		if name == src.Name {
+			continue
+		}
+		for _, p := range paths {
+			if _, ok := files[p]; ok {
+				return fmt.Errorf("issuer %s is also served for %s, give the clusters different issuer URLs", issuer.Discovery.Issuer, name)
+			}
+		}
+	}
+	for _, p := range s.paths[src.Name] {
+		if _, ok := files[p]; !ok {
+			delete(s.docs, p)
+		}
+	}
+	paths := []string{}
+	for p, content := range files {
+		s.docs[p] = newDocument(content, s.docs[p])
+		paths = append(paths, p)
+	}
+	s.paths[src.Name] = paths
+	return nil
+}
+
+// Run refreshes all sources every interval until the context is done.
+func (s *Server) Run(ctx context.Context, interval time.Duration) {
+	log := logger.NewLogger()
+	timeout := s.RefreshTimeout
+	if timeout == 0 {
+		timeout = DefaultRefreshTimeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, src := range s.Sources {
+			refreshCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := s.Refresh(refreshCtx, src)
+			cancel()
+			if err != nil {
+				log.Info("Can't refresh %s, serving the documents fetched before: %v", src.Name, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready reports whether the documents of every source have been fetched.
+func (s *Server) Ready() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, src := range s.Sources {
+		if len(s.paths[src.Name]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		if !s.Ready() {
+			http.Error(w, "not all clusters fetched yet", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	s.mu.RLock()
+	doc, ok := s.docs[r.URL.Path]
+	s.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(s.MaxAge.Seconds())))
+	w.Header().Set("ETag", doc.etag)
+	// answers If-None-Match with 304 and handles HEAD
+	http.ServeContent(w, r, "", doc.modified, bytes.NewReader(doc.content))
+}
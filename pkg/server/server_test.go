@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+func testIssuer(t *testing.T, issuerURL, kid string) *oidc.Issuer {
+	issuer, err := oidc.NewIssuerFromJWKS(issuerURL, &oidc.JWKS{Keys: []oidc.JSONWebKey{
+		{KeyType: "RSA", KeyID: kid, N: "n-" + kid, E: "AQAB"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer
+}
+
+// get returns the status and body s serves for path.
+func get(s *Server, path string) (int, string) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestUpdate(t *testing.T) {
+	s := &Server{}
+	a := Source{Name: "a", IssuerURL: "https://oidc.example.com/a"}
+	b := Source{Name: "b"}
+	if err := s.update(a, testIssuer(t, a.IssuerURL, "ka")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.update(b, testIssuer(t, "https://oidc.example.com/b/", "kb")); err != nil {
+		t.Fatal(err)
+	}
+	for path, kid := range map[string]string{"/a" + oidc.JWKSPath: "ka", "/b" + oidc.JWKSPath: "kb"} {
+		if code, body := get(s, path); code != http.StatusOK || !strings.Contains(body, kid) {
+			t.Errorf("GET %s = %d %s, want the key %s", path, code, body, kid)
+		}
+	}
+	if code, body := get(s, "/a"+oidc.DiscoveryPath); code != http.StatusOK || !strings.Contains(body, `"https://oidc.example.com/a"`) {
+		t.Errorf("GET /a%s = %d %s, want issuer https://oidc.example.com/a", oidc.DiscoveryPath, code, body)
+	}
+
+	// a cluster whose tokens have another issuer is not served
+	c := Source{Name: "c", IssuerURL: "https://oidc.example.com/c"}
+	err := s.update(c, testIssuer(t, "https://kubernetes.default.svc", "kc"))
+	if err == nil || !strings.HasPrefix(err.Error(), "c: ") {
+		t.Errorf("update() with another token issuer = %v, want an error naming the cluster", err)
+	}
+	if code, _ := get(s, "/c"+oidc.JWKSPath); code != http.StatusNotFound {
+		t.Errorf("GET /c%s = %d, want %d", oidc.JWKSPath, code, http.StatusNotFound)
+	}
+
+	// another cluster at the path of a keeps a's documents
+	if err := s.update(c, testIssuer(t, "https://other.example.com/a", "kc")); err == nil {
+		t.Error("update() of a colliding issuer succeeded")
+	}
+	if code, body := get(s, "/a"+oidc.JWKSPath); code != http.StatusOK || !strings.Contains(body, "ka") {
+		t.Errorf("GET /a%s after collision = %d %s, want the key ka", oidc.JWKSPath, code, body)
+	}
+
+	// a cluster whose issuer moves is only served at the new path
+	if err := s.update(b, testIssuer(t, "https://oidc.example.com/b2", "kb")); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := get(s, "/b"+oidc.JWKSPath); code != http.StatusNotFound {
+		t.Errorf("GET /b%s after the issuer moved = %d, want %d", oidc.JWKSPath, code, http.StatusNotFound)
+	}
+	if code, _ := get(s, "/b2"+oidc.JWKSPath); code != http.StatusOK {
+		t.Errorf("GET /b2%s = %d, want %d", oidc.JWKSPath, code, http.StatusOK)
+	}
+}
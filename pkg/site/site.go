@@ -0,0 +1,126 @@
+package site
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/shundezhang/oidc-config/pkg/logger"
+	"github.com/shundezhang/oidc-config/pkg/oidc"
+)
+
+var nginxTemplate = `# Serve OIDC discovery documents for {{ .Issuer }}
+server {
+    listen {{ .Port }} ssl;
+    server_name {{ .Hostname }};
+    # replace with the certificate and key of {{ .Hostname }}
+    ssl_certificate /etc/nginx/ssl/{{ .Hostname }}.crt;
+    ssl_certificate_key /etc/nginx/ssl/{{ .Hostname }}.key;
+    root {{ .Root }};
+
+    location = {{ .Path }}/.well-known/openid-configuration {
+        default_type application/json;
+        add_header Cache-Control "public, max-age=300";
+    }
+    location = {{ .Path }}/openid/v1/jwks {
+        default_type application/json;
+        add_header Cache-Control "public, max-age=300";
+    }
+}
+`
+
+var caddyTemplate = `# Serve OIDC discovery documents for {{ .Issuer }}
+{{ .Host }} {
+    root * {{ .Root }}
+    @oidc path {{ .Path }}/.well-known/openid-configuration {{ .Path }}/openid/v1/jwks
+    header @oidc Content-Type application/json
+    header @oidc Cache-Control "public, max-age=300"
+    file_server
+}
+`
+
+var serverTemplates = map[string]struct {
+	file     string
+	template string
+}{
+	"nginx": {"nginx.conf", nginxTemplate},
+	"caddy": {"Caddyfile", caddyTemplate},
+}
+
+// Write writes the discovery document and key set under dir, using the same
+// layout as the object keys in the S3 bucket.
+func Write(dir, issuerPath string, config, jwks []byte) error {
+	log := logger.NewLogger()
+	files := []struct {
+		path    string
+		content []byte
+	}{
+		{issuerPath + oidc.DiscoveryPath, config},
+		{issuerPath + oidc.JWKSPath, jwks},
+	}
+	for _, f := range files {
+		file := filepath.Join(dir, filepath.FromSlash(f.path))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		log.Info("Writing %s...", file)
+		if err := ioutil.WriteFile(file, f.content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteServerConfig writes an nginx or caddy config snippet to file that
+// serves the documents written by Write to dir with a JSON Content-Type. The
+// file must be outside dir, where it would be served too; if empty, it is
+// written next to dir, e.g. public.nginx.conf for public. It returns the path
+// of the file written.
+func WriteServerConfig(dir, file, server, issuer string) (string, error) {
+	t, ok := serverTemplates[server]
+	if !ok {
+		return "", fmt.Errorf("web server %s not supported, use nginx or caddy", server)
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if file == "" {
+		file = root + "." + t.file
+	}
+	if file, err = filepath.Abs(file); err != nil {
+		return "", err
+	}
+	if rel, err := filepath.Rel(root, file); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is in %s, where it would be served", file, dir)
+	}
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+	tmpl, err := template.New(server).Parse(t.template)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	err = tmpl.Execute(&b, map[string]string{
+		"Issuer":   issuer,
+		"Host":     u.Host,
+		"Hostname": u.Hostname(),
+		"Port":     port,
+		"Path":     strings.TrimSuffix(u.Path, "/"),
+		"Root":     root,
+	})
+	if err != nil {
+		return "", err
+	}
+	return file, ioutil.WriteFile(file, []byte(b.String()), 0644)
+}
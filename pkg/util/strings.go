@@ -0,0 +1,12 @@
+// Package util has small helpers shared by the other packages.
+package util
+
+// Contains reports whether value is one of values.
+func Contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}